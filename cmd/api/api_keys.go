@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// the longest an old key can keep working after it has been rotated
+const maxAPIKeyGracePeriod = 7 * 24 * time.Hour
+
+// authenticateAPIKey authenticates a request made with an API key, returning the request
+// with the service account in its context. Every use is audited, and so is every rejected
+// attempt with a key that exists.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string) (*http.Request, bool) {
+	if !strings.HasPrefix(plaintext, data.APIKeyPrefix) {
+		app.invalidAPIKeyResponse(w, r)
+		return r, false
+	}
+
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return r, false
+	}
+
+	ip := realip.FromRequest(r)
+	keyID := strconv.FormatInt(key.ID, 10)
+
+	var reason string
+	switch {
+	case key.RevokedAt != nil:
+		reason = "revoked"
+	case !key.Active():
+		reason = "expired"
+	case !key.AllowsIP(ip):
+		reason = "ip_not_allowed"
+	}
+	if reason != "" {
+		app.recordAudit(r, "api_key.rejected", "api_key", keyID, nil, envelope{"reason": reason, "method": r.Method, "path": r.URL.Path})
+		app.invalidAPIKeyResponse(w, r)
+		return r, false
+	}
+
+	user, err := app.models.Users.GetUser(key.ServiceAccountID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return r, false
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return r, false
+	}
+
+	firstUse, err := app.models.APIKeys.Touch(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return r, false
+	}
+
+	r = app.contextSetAPIKey(r, user, key)
+
+	// audit the first request in each touch interval rather than every request, so a busy
+	// integration doesn't flood the audit log. last_used_at tracks the rest.
+	if firstUse {
+		app.recordAudit(r, "api_key.use", "api_key", keyID, nil, envelope{"method": r.Method, "path": r.URL.Path})
+	}
+
+	return r, true
+}
+
+// list the service accounts
+func (app *application) ListServiceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := app.models.APIKeys.GetAllServiceAccounts()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"service_accounts": accounts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// create a service account that integrations can be issued API keys for
+func (app *application) CreateServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+	account := &data.ServiceAccount{
+		Name:        input.Name,
+		Description: input.Description,
+		CreatedBy:   &admin.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateServiceAccount(v, account); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.APIKeys.InsertServiceAccount(account)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateServiceAccountName):
+			v.AddError("name", "validation.service_account_taken")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "service_account.create", "service_account", strconv.FormatInt(account.ID, 10), nil, account)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"service_account": account}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// delete a service account, which also revokes all of its keys
+func (app *application) DeleteServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.getServiceAccountForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.APIKeys.DeleteServiceAccount(account.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "service_account.delete", "service_account", strconv.FormatInt(account.ID, 10), account, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Service account deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list a service account's keys. Only the prefix of each key is shown.
+func (app *application) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.getServiceAccountForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := app.models.APIKeys.GetAllForServiceAccount(account.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issue a new key for a service account. The key itself is only returned in this response.
+func (app *application) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.getServiceAccountForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		AllowedIPs  []string   `json:"allowed_ips"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		ServiceAccountID: account.ID,
+		Name:             input.Name,
+		Permissions:      input.Permissions,
+		AllowedIPs:       input.AllowedIPs,
+		ExpiresAt:        input.ExpiresAt,
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "api_key.create", "api_key", strconv.FormatInt(key.ID, 10), nil, envelope{
+		"service_account_id": key.ServiceAccountID,
+		"name":               key.Name,
+		"prefix":             key.Prefix,
+		"permissions":        key.Permissions,
+		"allowed_ips":        key.AllowedIPs,
+		"expires_at":         key.ExpiresAt,
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replace a key with a new one that has the same permissions. The old key keeps working
+// for the requested grace period, or stops straight away if none is given.
+func (app *application) RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.getAPIKeyForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		GracePeriod string `json:"grace_period"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var grace time.Duration
+
+	v := validator.New()
+	if input.GracePeriod != "" {
+		grace, err = time.ParseDuration(input.GracePeriod)
+		v.Check(err == nil, "grace_period", "validation.duration", "example", "24h")
+		v.Check(grace >= 0 && grace <= maxAPIKeyGracePeriod, "grace_period", "validation.max_value", "max", "168h")
+	}
+	v.Check(key.Active() && key.ReplacedBy == nil, "api_key", "validation.key_not_rotatable")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before := envelope{"expires_at": key.ExpiresAt}
+
+	replacement, err := app.models.APIKeys.Rotate(key, grace)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "api_key.rotate", "api_key", strconv.FormatInt(key.ID, 10), before, envelope{
+		"expires_at":  key.ExpiresAt,
+		"replaced_by": replacement.ID,
+		"prefix":      replacement.Prefix,
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": replacement, "previous_api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke a key so it can't be used any more
+func (app *application) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.getAPIKeyForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.APIKeys.Revoke(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "api_key.revoke", "api_key", strconv.FormatInt(key.ID, 10), nil, envelope{"revoked_at": key.RevokedAt})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key revoked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getServiceAccountForAdmin looks up the service account named by the id route parameter,
+// sending the error response itself when it can't
+func (app *application) getServiceAccountForAdmin(w http.ResponseWriter, r *http.Request) (*data.ServiceAccount, bool) {
+	id, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return nil, false
+	}
+
+	account, err := app.models.APIKeys.GetServiceAccount(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return account, true
+}
+
+// getAPIKeyForAdmin looks up the key named by the key_id route parameter, which has to
+// belong to the service account named by the id route parameter
+func (app *application) getAPIKeyForAdmin(w http.ResponseWriter, r *http.Request) (*data.APIKey, bool) {
+	accountID, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return nil, false
+	}
+
+	keyID, err := app.getRequestIDParam(r, "key_id")
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return nil, false
+	}
+
+	key, err := app.models.APIKeys.Get(accountID, keyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return key, true
+}
@@ -15,11 +15,31 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// permissionsContextKey holds the memoized permissions of the user in the context
+const permissionsContextKey = contextKey("permissions")
+
+// sessionContextKey holds the session the request's authentication token belongs to
+const sessionContextKey = contextKey("session")
+
+// apiKeyContextKey holds the API key a service account authenticated with
+const apiKeyContextKey = contextKey("api_key")
+
+// requestIDContextKey holds the ID the request is logged and audited under
+const requestIDContextKey = contextKey("request_id")
+
+// accessLogContextKey holds the access log entry that is written once the request is done
+const accessLogContextKey = contextKey("access_log")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
-// key.
+// key. A fresh permissions holder is added alongside it, so the permissions of the
+// new user are looked up again the first time they're needed.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if entry := contextGetAccessLog(r); entry != nil {
+		entry.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, permissionsContextKey, &requestPermissions{})
 	return r.WithContext(ctx)
 }
 
@@ -34,3 +54,48 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetSession adds the session of the authenticated user to the request context
+func (app *application) contextSetSession(r *http.Request, session *data.Session) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, session)
+	return r.WithContext(ctx)
+}
+
+// contextGetSession returns the session of the authenticated user, or nil for anonymous
+// requests and for tokens that were issued before sessions existed
+func (app *application) contextGetSession(r *http.Request) *data.Session {
+	session, ok := r.Context().Value(sessionContextKey).(*data.Session)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+// contextSetAPIKey adds the service account user and the API key it authenticated with
+// to the request context. The request is limited to the key's permissions, so they are
+// put in place of the ones that would otherwise be looked up for the user.
+func (app *application) contextSetAPIKey(r *http.Request, user *data.User, key *data.APIKey) *http.Request {
+	if entry := contextGetAccessLog(r); entry != nil {
+		entry.userID = user.ID
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, permissionsContextKey, &requestPermissions{loaded: true, permissions: key.Permissions})
+	ctx = context.WithValue(ctx, apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or nil for
+// requests that weren't
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	if !ok {
+		return nil
+	}
+	return key
+}
+
+// contextGetRequestID returns the ID given to the request by the requestID middleware
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
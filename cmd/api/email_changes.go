@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/araromirichard/internal/validator"
+)
+
+// how long the confirmation link sent to the new address and the revert link sent to
+// the old address stay valid, and how often changes that were never confirmed are
+// cancelled
+const (
+	emailChangeConfirmTTL      = 24 * time.Hour
+	emailChangeRevertTTL       = 7 * 24 * time.Hour
+	emailChangeCleanupInterval = time.Hour
+)
+
+// start moving the authenticated user to a new email address. The new address has to be
+// confirmed, and the old address is told about the change with a link to revert it.
+func (app *application) RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	match, err := user.Password.Match(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "validation.incorrect")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// the revert link sent to the original address has to keep working for as long as it
+	// is valid, so the address can't be changed again until then
+	_, err = app.models.EmailChanges.GetConfirmedSinceForUser(user.ID, time.Now().Add(-emailChangeRevertTTL))
+	if err == nil {
+		v.AddError("email", "validation.email_change_revertible")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	change := &data.EmailChange{
+		UserID:   user.ID,
+		OldEmail: user.Email,
+		NewEmail: input.Email,
+	}
+
+	err = app.models.EmailChanges.Insert(change, time.Now().Add(-emailChangeConfirmTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "validation.email_taken")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// only the confirmation link from the latest request works. Revert links are kept, as
+	// they all went to the current address and undo whichever change is made.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	confirmToken, err := app.models.Tokens.New(user.ID, emailChangeConfirmTTL, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	revertToken, err := app.models.Tokens.New(user.ID, emailChangeRevertTTL, data.ScopeEmailRevert)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the addresses themselves are kept out of the audit log, which outlives the account
+	app.recordAudit(r, "user.email_change_request", "user", strconv.FormatInt(user.ID, 10), nil, envelope{"email_change_id": change.ID})
+
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
+		logoURL := "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png"
+
+		err := app.mailer.Send(change.NewEmail, locale, mailer.EmailChangeConfirmTemplate, map[string]interface{}{
+			"firstName":    user.FirstName,
+			"confirmToken": confirmToken.Plaintext,
+			"logoURL":      logoURL,
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+
+		err = app.mailer.Send(change.OldEmail, locale, mailer.EmailChangeNoticeTemplate, map[string]interface{}{
+			"firstName":   user.FirstName,
+			"newEmail":    change.NewEmail,
+			"revertToken": revertToken.Plaintext,
+			"logoURL":     logoURL,
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+
+	env := envelope{"message": "a confirmation email has been sent to your new email address"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirm a change of email address with the token sent to the new address
+func (app *application) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_confirmation_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	change, err := app.models.EmailChanges.GetPendingForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_confirmation_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.EmailChanges.Confirm(change)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "validation.email_taken")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	app.recordAudit(r, "user.email_change", "user", strconv.FormatInt(user.ID, 10), nil, envelope{"email_change_id": change.ID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your email address has been changed", "email": change.NewEmail}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// undo a change of email address with the token sent to the old address. Since the
+// change may not have been made by the account owner, every session is signed out.
+func (app *application) RevertEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailRevert, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	change, err := app.models.EmailChanges.GetRevertibleForUser(user.ID, time.Now().Add(-emailChangeRevertTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.EmailChanges.Revert(change)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "validation.email_taken")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailRevert} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	app.recordAudit(r, "user.email_change_revert", "user", strconv.FormatInt(user.ID, 10), nil, envelope{"email_change_id": change.ID})
+
+	env := envelope{"message": "the email change has been undone and you have been signed out everywhere, we recommend resetting your password"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runEmailChangeCleanup periodically cancels the email changes whose confirmation link
+// has expired, so an address nobody confirmed doesn't stay reserved
+func (app *application) runEmailChangeCleanup() {
+	for {
+		app.background(func() {
+			err := app.models.EmailChanges.CancelStale(time.Now().Add(-emailChangeConfirmTTL))
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+		time.Sleep(emailChangeCleanupInterval)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/i18n"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/araromirichard/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// emailTemplateSummary is an embedded email template along with the versions that
+// override it
+type emailTemplateSummary struct {
+	Name      string                `json:"name"`
+	Overrides []*data.EmailTemplate `json:"overrides"`
+}
+
+// loadEmailTemplate is the mailer's TemplateLoader. When the database can't be reached
+// the embedded template is sent rather than no email at all.
+func (app *application) loadEmailTemplate(templateFile, locale string) (string, bool) {
+	t, err := app.models.EmailTemplates.GetActive(templateFile, locale)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.PrintError(err, map[string]string{"template": templateFile, "locale": locale})
+		}
+		return "", false
+	}
+	return t.Source, true
+}
+
+// list the email templates and the versions currently overriding them
+func (app *application) ListEmailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	active, err := app.models.EmailTemplates.GetAllActive()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	templates := []emailTemplateSummary{}
+	for _, name := range mailer.Templates() {
+		summary := emailTemplateSummary{Name: name, Overrides: []*data.EmailTemplate{}}
+		for _, t := range active {
+			if t.Name == name {
+				summary.Overrides = append(summary.Overrides, t)
+			}
+		}
+		templates = append(templates, summary)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_templates": templates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list every saved version of a template in a locale
+func (app *application) ListEmailTemplateVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	name, locale, ok := app.readEmailTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := app.models.EmailTemplates.GetAllVersions(name, locale)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"versions": versions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// save a new version of a template, which is used for every email sent from then on
+func (app *application) CreateEmailTemplateVersionHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Locale string `json:"locale"`
+		Source string `json:"source"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+	t := &data.EmailTemplate{
+		Name:      name,
+		Locale:    input.Locale,
+		Source:    input.Source,
+		CreatedBy: &admin.ID,
+	}
+	if t.Locale == "" {
+		t.Locale = i18n.Default
+	}
+
+	v := validator.New()
+	if data.ValidateEmailTemplate(v, t); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// Check the template renders before it is put in use, so a mistake can't stop the
+	// email from being sent.
+	tmpl, err := mailer.Parse(name, t.Locale, t.Source)
+	if err == nil {
+		_, err = mailer.RenderStrict(tmpl, mailer.SampleData())
+	}
+	if err != nil {
+		v.AddError("source", "validation.template", "error", err.Error())
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.EmailTemplates.Insert(t)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "email_template.create", "email_template", strconv.FormatInt(t.ID, 10), nil, envelope{
+		"name":    t.Name,
+		"locale":  t.Locale,
+		"version": t.Version,
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"email_template": t}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// put an earlier version of a template back in use
+func (app *application) ActivateEmailTemplateVersionHandler(w http.ResponseWriter, r *http.Request) {
+	name, locale, ok := app.readEmailTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("version"))
+	if err != nil || version < 1 {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	t, err := app.models.EmailTemplates.GetVersion(name, locale, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.EmailTemplates.Activate(t)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "email_template.activate", "email_template", strconv.FormatInt(t.ID, 10), nil, envelope{
+		"name":    t.Name,
+		"locale":  t.Locale,
+		"version": t.Version,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_template": t}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// stop overriding a template in a locale, so the embedded one is sent again
+func (app *application) ResetEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name, locale, ok := app.readEmailTemplateParams(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := app.models.EmailTemplates.GetActive(name, locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.EmailTemplates.Deactivate(name, locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "email_template.reset", "email_template", strconv.FormatInt(before.ID, 10), envelope{
+		"name":    before.Name,
+		"locale":  before.Locale,
+		"version": before.Version,
+	}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Email template reset to the default successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// render a template with sample data. The template in use is previewed unless a saved
+// version or unsaved source is given, and data replaces individual sample values.
+func (app *application) PreviewEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Locale  string                 `json:"locale"`
+		Version *int                   `json:"version"`
+		Source  *string                `json:"source"`
+		Data    map[string]interface{} `json:"data"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Locale == "" {
+		input.Locale = i18n.Default
+	}
+
+	v := validator.New()
+	v.Check(i18n.Supported(input.Locale), "locale", "validation.one_of", "values", "en or fr")
+	v.Check(input.Version == nil || input.Source == nil, "source", "validation.exclusive", "field", "version")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	sample := mailer.SampleData()
+	for key, value := range input.Data {
+		sample[key] = value
+	}
+
+	var tmpl *template.Template
+	switch {
+	case input.Source != nil:
+		tmpl, err = mailer.Parse(name, input.Locale, *input.Source)
+	case input.Version != nil:
+		var t *data.EmailTemplate
+		t, err = app.models.EmailTemplates.GetVersion(name, input.Locale, *input.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("version", "validation.not_exist")
+				app.failedValidationResponse(w, r, v)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		tmpl, err = mailer.Parse(name, t.Locale, t.Source)
+	default:
+		tmpl, err = app.mailer.Template(name, input.Locale)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if err != nil {
+		v.AddError("source", "validation.template", "error", err.Error())
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	message, err := mailer.RenderStrict(tmpl, sample)
+	if err != nil {
+		v.AddError("source", "validation.template", "error", err.Error())
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preview": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readEmailTemplateName reads the name route parameter, sending a 404 when it isn't one
+// of the embedded templates
+func (app *application) readEmailTemplateName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if !mailer.HasTemplate(name) {
+		app.NotFoundResponse(w, r)
+		return "", false
+	}
+	return name, true
+}
+
+// readEmailTemplateParams reads the name route parameter and the locale query string
+// parameter, which defaults to English
+func (app *application) readEmailTemplateParams(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	locale := app.readString(r.URL.Query(), "locale", i18n.Default)
+
+	v := validator.New()
+	if v.Check(i18n.Supported(locale), "locale", "validation.one_of", "values", "en or fr"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return "", "", false
+	}
+
+	return name, locale, true
+}
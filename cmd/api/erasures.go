@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/araromirichard/internal/validator"
+)
+
+// how often due account erasures are looked for, how many are processed per run, and
+// how long a run has to process them before another instance may pick them up. Each run
+// also destroys up to assetDeletionBatchSize queued images.
+const (
+	erasureInterval        = time.Hour
+	erasureBatchSize       = 50
+	erasureLease           = 30 * time.Minute
+	assetDeletionBatchSize = 200
+)
+
+// schedule the authenticated user's account for erasure after the grace period
+func (app *application) RequestAccountErasureHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// the user has to confirm their password before their account is scheduled for erasure
+	v := validator.New()
+	v.Check(input.Password != "", "password", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	match, err := user.Password.Match(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("password", "validation.incorrect")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	erasure, err := app.models.Erasures.Insert(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrErasurePending):
+			v.AddError("erasure", "validation.erasure_scheduled")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "user.erasure_request", "user", strconv.FormatInt(user.ID, 10), nil, erasure)
+
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
+		emailData := map[string]interface{}{
+			"firstName":    user.FirstName,
+			"scheduledFor": erasure.ScheduledFor.Format("2 January 2006"),
+			"logoURL":      "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
+		}
+		err := app.mailer.Send(user.Email, locale, mailer.AccountErasureTemplate, emailData)
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+
+	env := envelope{
+		"erasure": erasure,
+		"message": "your account is scheduled for erasure, you can cancel this at any time before then",
+	}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancel the authenticated user's pending account erasure
+func (app *application) CancelAccountErasureHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	erasure, err := app.models.Erasures.GetPendingForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Erasures.Cancel(erasure)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "user.erasure_cancel", "user", strconv.FormatInt(user.ID, 10), nil, erasure)
+
+	env := envelope{"erasure": erasure, "message": "your account erasure has been cancelled"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runAccountErasures periodically erases the accounts whose grace period has run out.
+// Each run is tracked by the wait group so shutdown doesn't cut an erasure short.
+func (app *application) runAccountErasures() {
+	for {
+		app.background(app.processDueErasures)
+		time.Sleep(erasureInterval)
+	}
+}
+
+func (app *application) processDueErasures() {
+	erasures, err := app.models.Erasures.ClaimDue(erasureBatchSize, erasureLease)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, erasure := range erasures {
+		err := app.models.Erasures.Erase(erasure)
+		if err != nil {
+			// a cancelled erasure is simply skipped, anything else stays pending and is
+			// retried once the claim expires
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, map[string]string{
+					"erasure_id": strconv.FormatInt(erasure.ID, 10),
+					"user_id":    strconv.FormatInt(erasure.UserID, 10),
+				})
+			}
+			continue
+		}
+
+		app.writeAudit(&data.AuditEvent{
+			Action:     "user.erase",
+			EntityType: "user",
+			EntityID:   strconv.FormatInt(erasure.UserID, 10),
+		}, nil, erasure)
+	}
+
+	// The erased users' photos were queued in the same transaction that deleted them, so
+	// they are only destroyed once the erasure has committed. Anything left over from an
+	// earlier run is retried too.
+	app.deleteQueuedAssets()
+}
+
+// deleteQueuedAssets destroys the images queued in asset_deletions, removing each one from
+// the queue once it is gone. An image that fails stays queued for the next run.
+func (app *application) deleteQueuedAssets() {
+	deletions, err := app.models.AssetDeletions.GetQueued(assetDeletionBatchSize)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, deletion := range deletions {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := app.uploader.DeleteImage(ctx, deletion.PublicID)
+		cancel()
+		if err == nil {
+			err = app.models.AssetDeletions.Done(deletion.ID)
+		}
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("deleting asset %s: %w", deletion.PublicID, err), nil)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
-	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/i18n"
+	"github.com/araromirichard/internal/validator"
 )
 
 // logError method is to log errors
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
+		"request_id":     app.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -15,6 +21,9 @@ func (app *application) logError(r *http.Request, err error) {
 
 // create a generic heper that outsput a error message in ajson format for the client
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	// Messages are in the request's locale, so caches have to keep one copy per language
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", app.locale(r))
 	env := envelope{"error": message}
 
 	error := app.writeJSON(w, status, env, nil)
@@ -34,7 +43,7 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 
 	// declare a message variable that holds a readable striing message
 
-	message := "The server encountered an error and was unable to process your request"
+	message := app.translate(r, "error.server")
 
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 
@@ -42,7 +51,7 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 
 // function for not found 404 response and \JSON response to the client
 func (app *application) NotFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "The requested resource could not be found"
+	message := app.translate(r, "error.not_found")
 
 	app.errorResponse(w, r, http.StatusNotFound, message)
 
@@ -50,62 +59,103 @@ func (app *application) NotFoundResponse(w http.ResponseWriter, r *http.Request)
 
 // MethodNotAllowedResponse
 func (app *application) MethodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not suported for this resource", r.Method)
+	message := app.translate(r, "error.method_not_allowed", "method", r.Method)
 
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
 // badRequestResponse
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, i18n.Localize(app.locale(r), err.Error()))
 }
 
-// Note that the errors parameter here has the type map[string]string, which is exactly
-// the same as the errors map contained in our Validator type.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// failedValidationResponse sends the validator's errors, translated into the request's
+// locale from the message codes they were added with
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, v.Translate(app.locale(r)))
 }
 
 // error response for edit conflict
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
+	message := app.translate(r, "error.edit_conflict")
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
 // error for rate limit exceeded
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "too many requests, please try again later"
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := app.translate(r, "error.rate_limit_exceeded")
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
 // error response when the get user by email does not see any matching record
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
+	message := app.translate(r, "error.invalid_credentials")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 // error response for invalid auth token response
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
+	message := app.translate(r, "error.invalid_authentication_token")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// error response for an API key that is unknown, revoked, expired or used from an
+// address that isn't on its allow-list
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.invalid_api_key")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// error response for a refresh token that is unknown, expired or has been revoked
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.invalid_refresh_token")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// error response for accounts that must sign in with two-factor authentication first
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.mfa_required")
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// error response for logins turned away after too many failed attempts. Retry-After
+// tells the client how many seconds to wait.
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := app.translate(r, "error.login_throttled")
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // error response for auth requirement
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "You must be authenticated to access this resource"
+	message := app.translate(r, "error.authentication_required")
 
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 // error response for non active response
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "Your account must be activated to access this resource"
+	message := app.translate(r, "error.inactive_account")
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// error response for suspended accounts
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.account_suspended")
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// error response for an API key used on a route that only signed-in users can use
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.api_key_not_allowed")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
 // error response for permission denied
+
 func (app *application) permissionDeniedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "You do not have permission to access this resource"
+	message := app.translate(r, "error.permission_denied")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/araromirichard/internal/validator"
+)
+
+// how long a generated export and its download token stay valid, how long an export
+// can take to generate before it is given up on, and how often abandoned exports are
+// looked for
+const (
+	dataExportTTL             = 48 * time.Hour
+	dataExportTimeout         = 15 * time.Minute
+	dataExportCleanupInterval = time.Hour
+)
+
+// queue a copy of the authenticated user's data to be generated and emailed to them
+func (app *application) RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// only one export per user is generated at a time, but one that has been pending for
+	// longer than it could take was abandoned and doesn't hold up a new request
+	export, err := app.models.DataExports.Insert(user.ID, time.Now().Add(-dataExportTimeout))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExportPending):
+			env := envelope{"message": "an export of your data is already being prepared"}
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "user.export", "user", fmt.Sprint(user.ID), nil, nil)
+
+	app.backgroundForRequest(r, func() {
+		app.generateDataExport(r, export, user)
+	})
+
+	env := envelope{
+		"export":  export,
+		"message": "your data export has been queued, you will receive an email when it is ready",
+	}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// generateDataExport builds the archive for a queued export, stores it and emails the
+// user a download token. It runs in the background, so failures are recorded on the
+// export and logged.
+func (app *application) generateDataExport(r *http.Request, export *data.DataExport, user *data.User) {
+	fail := func(err error) {
+		app.logError(r, fmt.Errorf("data export %d: %w", export.ID, err))
+		if err := app.models.DataExports.MarkFailed(export, err.Error()); err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	archive, err := app.models.DataExports.Collect(user.ID)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	js, err := json.MarshalIndent(archive, "", "\t")
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	err = app.models.DataExports.MarkReady(export, js, time.Now().Add(dataExportTTL))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// a new download token replaces any left over from a previous export
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeDataExport, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.logError(r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, dataExportTTL, data.ScopeDataExport)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	emailData := map[string]interface{}{
+		"firstName":     user.FirstName,
+		"downloadToken": token.Plaintext,
+		"expiresIn":     "48 hours",
+		"logoURL":       "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
+	}
+	err = app.mailer.Send(user.Email, app.userLocale(r, user), mailer.DataExportTemplate, emailData)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// runDataExportCleanup periodically fails exports whose generation was cut short by a
+// restart or crash, so they don't stay pending for ever
+func (app *application) runDataExportCleanup() {
+	for {
+		app.background(func() {
+			n, err := app.models.DataExports.FailStale(time.Now().Add(-dataExportTimeout))
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+			if n > 0 {
+				app.logger.PrintInfo("failed abandoned data exports", map[string]string{"count": fmt.Sprint(n)})
+			}
+		})
+		time.Sleep(dataExportCleanupInterval)
+	}
+}
+
+// download a generated data export using the token that was emailed to the user
+func (app *application) DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	tokenPlaintext := app.readString(r.URL.Query(), "token", "")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeDataExport, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_download_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	export, archive, err := app.models.DataExports.GetLatestReadyForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("ivywhiz-data-export-%d-%s.json", user.ID, export.CreatedAt.Format("20060102"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
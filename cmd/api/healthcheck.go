@@ -1,6 +1,15 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long each readiness check may take before it counts as failed
+const healthCheckTimeout = 2 * time.Second
 
 func (app *application) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	//define expected app health response data
@@ -22,3 +31,157 @@ func (app *application) HealthCheckHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+// liveness only reports that the process is up and serving requests. It doesn't check
+// any dependencies, so a database outage doesn't get every instance restarted.
+func (app *application) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dependencyProbe checks one dependency. Readiness fails if a required one is down.
+type dependencyProbe struct {
+	required bool
+	check    func(ctx context.Context) error
+}
+
+// dependencyCheck is the result of checking one dependency
+type dependencyCheck struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readiness reports whether the instance should be sent traffic. The database is always
+// checked and has to be up. Once shutdown has begun, readiness fails straight away so
+// the load balancer stops sending traffic while in-flight requests finish. The public
+// endpoint only gives the overall status and whether each dependency is up; the errors,
+// the pool stats and the optional probes are on the internal port, see
+// InternalReadinessHandler.
+func (app *application) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	app.readiness(w, r, false)
+}
+
+// InternalReadinessHandler is the detailed readiness check served on the metrics port.
+// SMTP, Cloudinary and Pusher are only checked when asked for, for example
+// ?probe=smtp,cloudinary,pusher, and don't affect readiness since the API mostly works
+// without them.
+func (app *application) InternalReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	app.readiness(w, r, true)
+}
+
+func (app *application) readiness(w http.ResponseWriter, r *http.Request, detailed bool) {
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting_down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	probes := map[string]dependencyProbe{
+		"database": {required: true, check: app.db.PingContext},
+	}
+
+	var requested []string
+	if detailed {
+		requested = strings.Split(r.URL.Query().Get("probe"), ",")
+	}
+	for _, name := range requested {
+		switch strings.TrimSpace(name) {
+		case "smtp":
+			probes["smtp"] = dependencyProbe{check: func(ctx context.Context) error { return withContext(ctx, app.mailer.Ping) }}
+		case "cloudinary":
+			probes["cloudinary"] = dependencyProbe{check: app.uploader.Ping}
+		case "pusher":
+			probes["pusher"] = dependencyProbe{check: func(ctx context.Context) error { return app.notify.Configured() }}
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		checks = make(map[string]dependencyCheck, len(probes))
+		ready  = true
+	)
+
+	for name, probe := range probes {
+		wg.Add(1)
+		go func(name string, required bool, check func(ctx context.Context) error) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+
+			result := dependencyCheck{
+				Status:    "up",
+				Required:  required,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "down"
+				if detailed {
+					result.Error = err.Error()
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			checks[name] = result
+			if err != nil && required {
+				ready = false
+			}
+		}(name, probe.required, probe.check)
+	}
+	wg.Wait()
+
+	env := envelope{
+		"status": "ready",
+		"checks": checks,
+	}
+	if detailed {
+		stats := app.db.Stats()
+		env["database_pool"] = envelope{
+			"max_open":         stats.MaxOpenConnections,
+			"open":             stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+			"wait_count":       stats.WaitCount,
+			"wait_duration_ms": stats.WaitDuration.Milliseconds(),
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		env["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// withContext runs a check that doesn't take a context, giving up on it when the context
+// is done. The check carries on in the background until it returns by itself.
+func withContext(ctx context.Context, check func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -24,12 +24,17 @@ type envelope map[string]interface{}
 
 // get the id from a current request
 func (app *application) getRequestID(r *http.Request) (int64, error) {
+	return app.getRequestIDParam(r, "id")
+}
+
+// getRequestIDParam reads a numeric ID from the named route parameter
+func (app *application) getRequestIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	//convert it to an int64
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("this is an invalid id parameter")
+		return 0, fmt.Errorf("this is an invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -166,7 +171,7 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, "validation.integer")
 		return defaultValue
 	}
 
@@ -186,6 +191,32 @@ func (app *application) readBool(qs url.Values, key string, defaultValue bool) b
 	return b
 }
 
+// readDate returns a time parsed from the query string, accepting either a plain date
+// (2006-01-02) or an RFC3339 timestamp. When endOfDay is set, a plain date is moved to
+// the start of the following day so it can be used as an exclusive upper bound. If the
+// key is missing nil is returned, and an unparsable value is recorded in the validator.
+func (app *application) readDate(qs url.Values, key string, endOfDay bool, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if endOfDay {
+			t = t.Add(24 * time.Hour)
+		}
+		return &t
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "validation.date")
+		return nil
+	}
+
+	return &t
+}
+
 // passwordMatches checks if the provided password matches the stored password hash
 func (app *application) passwordMatches(plainTextPassword, hashedPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainTextPassword))
@@ -214,14 +245,27 @@ func (app *application) createAppID(s string) string {
 //background func
 
 func (app *application) background(fn func()) {
+	app.backgroundForRequest(nil, fn)
+}
+
+// backgroundForRequest runs work started by a request in the background. A panic is
+// logged with the request's ID, and fn should log its own errors with app.logError(r, err)
+// so they can be traced back to the request too.
+func (app *application) backgroundForRequest(r *http.Request, fn func()) {
 	app.wg.Add(1)
+	app.backgroundInFlight.Add(1)
 
 	//lunch a background goroutine
 	go func() {
 		defer app.wg.Done()
+		defer app.backgroundInFlight.Add(-1)
 
 		defer func() {
 			if err := recover(); err != nil {
+				if r != nil {
+					app.logError(r, fmt.Errorf("%s", err))
+					return
+				}
 				app.logger.PrintError(fmt.Errorf("%s", err), nil)
 			}
 		}()
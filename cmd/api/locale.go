@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/i18n"
+	"github.com/araromirichard/internal/validator"
+)
+
+// locale returns the language to answer the request in. A locale the user has saved
+// wins over the Accept-Language header, and English is used when neither is supported.
+// Errors raised before the request is authenticated go by the header alone.
+func (app *application) locale(r *http.Request) string {
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok {
+		if user.Locale != "" && i18n.Supported(user.Locale) {
+			return user.Locale
+		}
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// userLocale returns the language to email user in. Users who haven't saved a locale
+// get the request's, as long as they are the ones making it or the request is anonymous,
+// as with password resets. Emails sent to someone else, such as a tutor verified by an
+// admin, fall back to English rather than the admin's language.
+func (app *application) userLocale(r *http.Request, user *data.User) string {
+	if user.Locale != "" && i18n.Supported(user.Locale) {
+		return user.Locale
+	}
+	if requester, ok := r.Context().Value(userContextKey).(*data.User); ok {
+		if !requester.IsAnonymous() && requester.ID != user.ID {
+			return i18n.Default
+		}
+	}
+	return app.locale(r)
+}
+
+// translate returns the message for code in the request's locale
+func (app *application) translate(r *http.Request, code string, args ...string) string {
+	return i18n.T(app.locale(r), code, args...)
+}
+
+// UpdateLocaleHandler saves the language the user wants emails and API messages in.
+// An empty locale goes back to following the Accept-Language header.
+func (app *application) UpdateLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Locale *string `json:"locale"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Locale != nil, "locale", "validation.required")
+	if input.Locale != nil && *input.Locale != "" {
+		v.Check(i18n.Supported(*input.Locale), "locale", "validation.one_of", "values", "en or fr")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Users.SetLocale(user, *input.Locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
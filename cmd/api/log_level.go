@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/araromirichard/internal/jsonlog"
+	"github.com/araromirichard/internal/validator"
+)
+
+// show the minimum level the logger currently writes
+func (app *application) GetLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// change the minimum log level without restarting, for example to turn on debug logging
+// while investigating a problem. The change only lasts until the instance restarts.
+func (app *application) UpdateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+
+	v := validator.New()
+	v.Check(err == nil, "level", "validation.one_of", "values", "debug, info, warn, error, fatal or off")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.recordAudit(r, "log.level_change", "log_level", "", envelope{"level": before.String()}, envelope{"level": level.String()})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
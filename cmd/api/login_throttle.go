@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/tomasen/realip"
+)
+
+// Limits on failed logins. After a few failures for an account every further attempt has
+// to wait a little longer than the last, doubling up to loginMaxDelay, and every
+// loginLockoutThreshold failures lock the account for loginLockoutDuration. An IP address
+// that fails more than loginIPLimit times is turned away whatever email it tries.
+const (
+	loginFailureWindow    = time.Hour
+	loginDelayThreshold   = 3
+	loginMaxDelay         = time.Minute
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 15 * time.Minute
+	loginIPLimit          = 100
+	loginIPRetryAfter     = 5 * time.Minute
+	loginCleanupInterval  = time.Hour
+)
+
+// loginDelay returns how long to wait after the most recent failure, given the number of
+// failures for the account
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayThreshold {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(failures-loginDelayThreshold))) * time.Second
+	if delay > loginMaxDelay || delay <= 0 {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+// loginAllowed checks whether a login for the email address may be attempted from the
+// client's IP address right now. If not, it writes a 429 response with a Retry-After
+// header and returns false.
+func (app *application) loginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if err == nil {
+		app.loginThrottledResponse(w, r, time.Until(lockedUntil))
+		return false
+	}
+
+	failures, err := app.models.LoginAttempts.RecentFailures(email, realip.FromRequest(r), time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if failures.ForIP >= loginIPLimit {
+		app.loginThrottledResponse(w, r, loginIPRetryAfter)
+		return false
+	}
+
+	if wait := time.Until(failures.LastFailure.Add(loginDelay(failures.ForEmail))); wait > 0 {
+		app.loginThrottledResponse(w, r, wait)
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure records a failed password or two-factor check and adds it to the
+// audit log. user is nil when the email doesn't belong to an account. When the account
+// reaches the lockout threshold it is locked and its owner is told by email.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) {
+	ip := realip.FromRequest(r)
+
+	err := app.models.LoginAttempts.Insert(email, ip, false)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email": email})
+		return
+	}
+
+	entityID := ""
+	if user != nil {
+		entityID = strconv.FormatInt(user.ID, 10)
+	}
+	// the email address isn't recorded, as the audit log outlives the account
+	app.recordAudit(r, "auth.login_failed", "user", entityID, nil, nil)
+
+	if user == nil {
+		return
+	}
+
+	failures, err := app.models.LoginAttempts.RecentFailures(email, ip, time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email": email})
+		return
+	}
+
+	if failures.ForEmail == 0 || failures.ForEmail%loginLockoutThreshold != 0 {
+		return
+	}
+
+	lockedUntil := time.Now().Add(loginLockoutDuration)
+	err = app.models.LoginAttempts.Lock(user.ID, lockedUntil)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email": email})
+		return
+	}
+
+	app.recordAudit(r, "user.lockout", "user", entityID, nil, envelope{"locked_until": lockedUntil, "failures": failures.ForEmail})
+
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
+		emailData := map[string]interface{}{
+			"firstName":   user.FirstName,
+			"ip":          ip,
+			"lockedUntil": lockedUntil.UTC().Format("2 January 2006 15:04 MST"),
+			"logoURL":     "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
+		}
+		err := app.mailer.Send(user.Email, locale, mailer.AccountLockedTemplate, emailData)
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
+
+// recordLoginSuccess records a successful login, which resets the account's failure count
+func (app *application) recordLoginSuccess(r *http.Request, email string) {
+	err := app.models.LoginAttempts.Insert(email, realip.FromRequest(r), true)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email": email})
+	}
+}
+
+// runLoginAttemptCleanup periodically removes login attempts that no longer count
+// towards any limit
+func (app *application) runLoginAttemptCleanup() {
+	for {
+		app.background(func() {
+			err := app.models.LoginAttempts.DeleteOlderThan(time.Now().Add(-loginFailureWindow))
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+		time.Sleep(loginCleanupInterval)
+	}
+}
+
+// lift a lockout from a user account and forget its failed logins
+func (app *application) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.LoginAttempts.Unlock(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginAttempts.ClearFailures(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "user.unlock", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "User unlocked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
+	"github.com/araromirichard/internal/ratelimit"
+	"github.com/araromirichard/internal/validator"
+)
+
+// how long a magic link stays valid
+const magicLinkTTL = 15 * time.Minute
+
+// each email address can be sent a few links straight away, then one every five minutes
+var magicLinkLimit = ratelimit.Limit{Rate: 1 / (5 * time.Minute).Seconds(), Burst: 3}
+
+// email a one-time login link. The response is the same whether or not the address belongs
+// to an account, so it can't be used to find out who has one.
+func (app *application) RequestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// the limit applies to every address, known or not, for the same reason
+	if !app.takeRateLimit(w, r, "magic_link", "magic_link:"+strings.ToLower(input.Email), magicLinkLimit) {
+		return
+	}
+
+	env := envelope{"message": "if an account exists for this email address, a login link has been sent to it"}
+
+	user, err := app.models.Users.GetUserByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated && !user.IsSuspended() {
+		token, err := app.models.Tokens.New(user.ID, magicLinkTTL, data.ScopeMagicLink)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		locale := app.userLocale(r, user)
+		app.backgroundForRequest(r, func() {
+			emailData := map[string]interface{}{
+				"firstName":      user.FirstName,
+				"magicLinkToken": token.Plaintext,
+				"logoURL":        "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
+			}
+			err := app.mailer.Send(user.Email, locale, mailer.MagicLinkTemplate, emailData)
+			if err != nil {
+				app.logError(r, err)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchange a magic link token for an authentication token. Users with two-factor
+// authentication enabled still have to complete the second step.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// the link only works once: it is deleted as it is redeemed, so of two requests racing
+	// with the same link only one gets through
+	userID, err := app.models.Tokens.Consume(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_login_link")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.GetUser(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "validation.invalid_login_link")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// using a link retires any other links sent to the user
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	if !app.loginAllowed(w, r, user.Email) {
+		return
+	}
+
+	challenge, err := app.newMFAChallenge(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if challenge != nil {
+		app.mfaChallengeResponse(w, r, challenge)
+		return
+	}
+
+	app.recordLoginSuccess(r, user.Email)
+
+	token, refreshToken, err := app.startSession(r, user, false)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/araromirichard/internal/data"
 	"github.com/araromirichard/internal/jsonlog"
 	"github.com/araromirichard/internal/mailer"
 	"github.com/araromirichard/internal/notification"
+	"github.com/araromirichard/internal/ratelimit"
+	"github.com/araromirichard/internal/totp"
 	"github.com/araromirichard/internal/uploader"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq" // Import the PostgreSQL driver
@@ -28,9 +32,16 @@ type config struct {
 	env     string // env declares the environment the application is running in
 	db      db
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps       float64
+		burst     int
+		authRPS   float64
+		authBurst int
+		readRPS   float64
+		readBurst int
+		userRPS   float64
+		userBurst int
+		enabled   bool
+		backend   string
 	}
 	cloudinary struct {
 		cloudName string
@@ -47,6 +58,29 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	mfa struct {
+		secretKey []byte
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	shutdown struct {
+		drain time.Duration
+	}
+	metrics struct {
+		port int
+	}
+	log struct {
+		level        string
+		file         string
+		maxSizeMB    int
+		maxBackups   int
+		sampleAccess bool
+	}
 }
 
 type db struct {
@@ -58,13 +92,23 @@ type db struct {
 
 // application struct defines the application object
 type application struct {
-	config   config
-	logger   *jsonlog.Logger
-	models   data.Models
-	uploader *uploader.ImageUploaderService
-	mailer   mailer.Mailer
-	notify   *notification.NotificationService
-	wg       sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	models      data.Models
+	uploader    *uploader.ImageUploaderService
+	mailer      mailer.Mailer
+	notify      *notification.NotificationService
+	permissions *permissionCache
+	mfaPolicies *mfaPolicyCache
+	limiter     ratelimit.Store
+	metrics     *appMetrics
+	db          *sql.DB
+	openAPI     envelope
+	wg          sync.WaitGroup
+	// backgroundInFlight counts the goroutines started by background() that are running
+	backgroundInFlight atomic.Int64
+	// shuttingDown is set as soon as a shutdown signal arrives, to fail readiness checks
+	shuttingDown atomic.Bool
 }
 
 func main() {
@@ -98,7 +142,14 @@ func main() {
 	// Rate limiter configuration
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate Limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate Limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 0.2, "Rate Limiter maximum requests per second to authentication endpoints")
+	flag.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 5, "Rate Limiter maximum burst to authentication endpoints")
+	flag.Float64Var(&cfg.limiter.readRPS, "limiter-read-rps", 10, "Rate Limiter maximum read requests per second")
+	flag.IntVar(&cfg.limiter.readBurst, "limiter-read-burst", 20, "Rate Limiter maximum read burst")
+	flag.Float64Var(&cfg.limiter.userRPS, "limiter-user-rps", 10, "Rate Limiter maximum requests per second for each authenticated user")
+	flag.IntVar(&cfg.limiter.userBurst, "limiter-user-burst", 30, "Rate Limiter maximum burst for each authenticated user")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable Rate Limiter")
+	flag.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Rate Limiter storage (memory|postgres), use postgres to share limits between instances")
 
 	// Cloudinary configuration
 	flag.StringVar(&cfg.cloudinary.cloudName, "cloudinary-cloud-name", os.Getenv("CLOUDINARY_CLOUD_NAME"), "Cloudinary cloud name")
@@ -118,11 +169,48 @@ func main() {
 		return nil
 	})
 
+	// Permission cache configuration
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", time.Minute, "How long user permissions and MFA policies are cached for (0 disables the cache)")
+
+	// MFA configuration
+	var mfaSecretKey string
+	flag.StringVar(&mfaSecretKey, "mfa-secret-key", os.Getenv("MFA_SECRET_KEY"), "Base64 encoded 32 byte key that TOTP secrets are encrypted with (secrets are stored in plaintext without it)")
+
+	// Token lifetimes
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "How long authentication (access) tokens are valid for")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "How long refresh tokens are valid for")
+
+	// Graceful shutdown
+	flag.DurationVar(&cfg.shutdown.drain, "shutdown-drain-period", 10*time.Second, "How long readiness fails before the server stops accepting requests on shutdown")
+
+	// Metrics
+	flag.IntVar(&cfg.metrics.port, "metrics-port", 9091, "Port to serve Prometheus metrics and the detailed readiness check on, kept off the public API port (0 disables it)")
+
+	// Logging configuration
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error|fatal|off)")
+	flag.StringVar(&cfg.log.file, "log-file", "", "Also write logs to this file, rotating it when it gets too big")
+	flag.IntVar(&cfg.log.maxSizeMB, "log-file-max-size", 100, "Size in megabytes at which the log file is rotated")
+	flag.IntVar(&cfg.log.maxBackups, "log-file-max-backups", 5, "Number of rotated log files to keep")
+	flag.BoolVar(&cfg.log.sampleAccess, "log-access-sample", false, "Only log a sample of successful requests in the access log (other responses are always logged)")
+
 	// Parse flags
 	flag.Parse()
 
 	// Initialize logger
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logger, err := newLogger(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	// Encrypt TOTP secrets when a key is configured. Secrets stored before it was set are
+	// still read in plaintext, and are encrypted when the user next enrols.
+	cfg.mfa.secretKey, err = totp.ParseKey(mfaSecretKey)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	if cfg.mfa.secretKey == nil {
+		logger.PrintInfo("no MFA secret key is configured, TOTP secrets are stored in plaintext", nil)
+	}
 
 	// Open DB connection
 	db, err := openDB(cfg)
@@ -145,21 +233,90 @@ func main() {
 	app := &application{
 		config:   cfg,
 		logger:   logger,
+		db:       db,
 		models:   data.NewModels(db),
 		uploader: uploader.New(cfg.cloudinary.cloudName, cfg.cloudinary.apiKey, cfg.cloudinary.apiSecret),
 		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
 		notify:   notification.New(pusherClient),
 	}
 
+	// Send the versions of email templates that admins have saved in place of the embedded
+	// ones, logging any that has stopped working and is skipped
+	app.mailer.LoadTemplatesFrom(app.loadEmailTemplate)
+	app.mailer.OnOverrideError(func(templateFile, locale string, err error) {
+		logger.PrintError(err, map[string]string{"template": templateFile, "locale": locale})
+	})
+
+	// Refuse to start with a missing or broken email template, rather than failing in the
+	// background the first time it is sent
+	if err := app.mailer.CheckTemplates(); err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Collect metrics for /metrics
+	app.metrics = app.newMetrics(db)
+
+	// Keep rate limits in memory, or in the database when they are shared between instances
+	switch cfg.limiter.backend {
+	case "memory":
+		app.limiter = ratelimit.NewMemoryStore()
+	case "postgres":
+		app.limiter = ratelimit.NewPostgresStore(db)
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown rate limiter backend %q", cfg.limiter.backend), nil)
+	}
+
+	// Cache permission checks and MFA policies, invalidating them when the database
+	// reports a change
+	if cfg.permissions.cacheTTL > 0 {
+		app.permissions = newPermissionCache(cfg.permissions.cacheTTL)
+		app.mfaPolicies = newMFAPolicyCache(cfg.permissions.cacheTTL)
+		go app.listenForPermissionChanges()
+	}
+
 	// Initialize the admin user
 	if err := app.initAdminUser(adminFirstName, adminLastName, adminEmail, adminPassword); err != nil {
 		logger.PrintFatal(err, nil)
 	}
 
+	// Erase accounts whose grace period has run out
+	go app.runAccountErasures()
+
+	// Fail data exports that were abandoned when an instance stopped
+	go app.runDataExportCleanup()
+
+	// Forget failed logins once they no longer count towards any limit
+	go app.runLoginAttemptCleanup()
+
+	// Release the addresses held by email changes that were never confirmed
+	go app.runEmailChangeCleanup()
+
+	// Forget rate limit buckets that have refilled
+	go app.runRateLimitCleanup()
+
 	// Start the server
 	logger.PrintFatal(app.serve(), nil)
 }
 
+// newLogger creates the logger, writing to stdout and to the log file if there is one
+func newLogger(cfg config) (*jsonlog.Logger, error) {
+	level, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.log.file == "" {
+		return jsonlog.New(os.Stdout, level), nil
+	}
+
+	file, err := jsonlog.OpenRotatingFile(cfg.log.file, int64(cfg.log.maxSizeMB)*1024*1024, cfg.log.maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonlog.New(jsonlog.Tee(os.Stdout, file), level), nil
+}
+
 // openDB establishes a connection to the database.
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
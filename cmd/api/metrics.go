@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/metrics"
+)
+
+// appMetrics holds the metrics the application updates as it runs. Everything else is
+// read when /metrics is scraped.
+type appMetrics struct {
+	registry            *metrics.Registry
+	requests            *metrics.CounterVec
+	requestDuration     *metrics.HistogramVec
+	rateLimitRejections *metrics.CounterVec
+	mailSent            *metrics.CounterVec
+	notificationsSent   *metrics.CounterVec
+}
+
+func (app *application) newMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:            registry,
+		requests:            registry.NewCounterVec("ivywhiz_http_requests_total", "HTTP requests handled, by route, method and status.", "route", "method", "status"),
+		requestDuration:     registry.NewHistogramVec("ivywhiz_http_request_duration_seconds", "Time taken to handle HTTP requests, by route, method and status.", metrics.DefaultBuckets, "route", "method", "status"),
+		rateLimitRejections: registry.NewCounterVec("ivywhiz_rate_limit_rejections_total", "Requests rejected by the rate limiter, by policy.", "policy"),
+		mailSent:            registry.NewCounterVec("ivywhiz_mail_sent_total", "Emails sent, by template and result.", "template", "result"),
+		notificationsSent:   registry.NewCounterVec("ivywhiz_notifications_sent_total", "Push notifications sent, by event and result.", "event", "result"),
+	}
+
+	dbConnections := registry.NewGaugeVec("ivywhiz_db_connections", "Database connections, by state.", "state")
+	dbMaxOpen := registry.NewGaugeVec("ivywhiz_db_max_open_connections", "Maximum number of open database connections.")
+	dbWaits := registry.NewCounterVec("ivywhiz_db_wait_total", "Times a query had to wait for a free database connection.")
+	dbWaitDuration := registry.NewCounterVec("ivywhiz_db_wait_seconds_total", "Time spent waiting for a free database connection.")
+	dbClosed := registry.NewCounterVec("ivywhiz_db_connections_closed_total", "Database connections closed, by reason.", "reason")
+	background := registry.NewGaugeVec("ivywhiz_background_goroutines", "Background tasks such as email sends that are still running.")
+	jobsQueued := registry.NewGaugeVec("ivywhiz_jobs_queued", "Jobs waiting to be processed, by job.", "job")
+
+	registry.OnScrape(func() {
+		stats := db.Stats()
+		dbConnections.Set(float64(stats.InUse), "in_use")
+		dbConnections.Set(float64(stats.Idle), "idle")
+		dbMaxOpen.Set(float64(stats.MaxOpenConnections))
+		dbWaits.Set(float64(stats.WaitCount))
+		dbWaitDuration.Set(stats.WaitDuration.Seconds())
+		dbClosed.Set(float64(stats.MaxIdleClosed), "max_idle")
+		dbClosed.Set(float64(stats.MaxIdleTimeClosed), "max_idle_time")
+		dbClosed.Set(float64(stats.MaxLifetimeClosed), "max_lifetime")
+
+		background.Set(float64(app.backgroundInFlight.Load()))
+
+		if pending, err := app.models.DataExports.CountPending(); err != nil {
+			app.logger.PrintError(err, nil)
+		} else {
+			jobsQueued.Set(float64(pending), "data_export")
+		}
+
+		if due, err := app.models.Erasures.CountDue(); err != nil {
+			app.logger.PrintError(err, nil)
+		} else {
+			jobsQueued.Set(float64(due), "account_erasure")
+		}
+	})
+
+	app.mailer.OnSend(func(templateFile string, err error) {
+		m.mailSent.Inc(templateFile, result(err))
+	})
+	app.notify.OnSend(func(event string, err error) {
+		m.notificationsSent.Inc(event, result(err))
+	})
+
+	return m
+}
+
+// metricsMethods are the request methods that get their own series. Clients can send
+// any method they like, so the rest are counted together.
+var metricsMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// observeRequest records a handled request. Requests that didn't match a route, or used
+// an unusual method, are grouped together, so clients can't create new series.
+func (m *appMetrics) observeRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	if !metricsMethods[method] {
+		method = "other"
+	}
+	code := strconv.Itoa(status)
+
+	m.requests.Inc(route, method, code)
+	m.requestDuration.Observe(duration.Seconds(), route, method, code)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// expose the metrics in the Prometheus text exposition format. They are served on their
+// own port rather than with the API, see serveMetrics.
+func (app *application) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := app.metrics.registry.Write(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/totp"
+	"github.com/araromirichard/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// the issuer shown next to the account in authenticator apps
+const totpIssuer = "IvyWhiz"
+
+// how long a user has to enter their code after the password step of a login
+const mfaChallengeTTL = 5 * time.Minute
+
+// newMFAChallenge issues the token for the second step of a login when the user has
+// two-factor authentication enabled. It returns nil when they don't.
+func (app *application) newMFAChallenge(user *data.User) (*data.Token, error) {
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if !mfa.Enabled() {
+		return nil, nil
+	}
+
+	return app.models.Tokens.New(user.ID, mfaChallengeTTL, data.ScopeMFA)
+}
+
+// mfaChallengeResponse tells the client to finish logging in at /v1/auth/login/mfa
+func (app *application) mfaChallengeResponse(w http.ResponseWriter, r *http.Request, challenge *data.Token) {
+	env := envelope{"mfa_required": true, "mfa_token": challenge}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTOTP checks a code from the user's authenticator app and records it as used so it
+// can't be replayed
+func (app *application) verifyTOTP(mfa *data.UserMFA, code string) (bool, error) {
+	secret, err := totp.Open(app.config.mfa.secretKey, mfa.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = app.models.MFA.UseStep(mfa.UserID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFACodeReused):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// mfaRequired reports whether the user's role requires two-factor authentication that the
+// current session hasn't been through
+func (app *application) mfaRequired(r *http.Request, user *data.User) (bool, error) {
+	if session := app.contextGetSession(r); session != nil && session.MFAVerified {
+		return false, nil
+	}
+
+	return app.mfaRequiredForRole(user.Role)
+}
+
+// complete a login with a code from the authenticator app or a recovery code
+func (app *application) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "validation.invalid_token")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// codes are guessed more easily than passwords, so they count towards the same limits
+	if !app.loginAllowed(w, r, user.Email) {
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !mfa.Enabled() {
+		v.AddError("mfa_token", "validation.invalid_token")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if input.Code != "" {
+		ok, err := app.verifyTOTP(mfa, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.recordLoginFailure(r, user.Email, user)
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+	} else {
+		err = app.models.MFA.UseRecoveryCode(user.ID, input.RecoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.recordLoginFailure(r, user.Email, user)
+				app.invalidCredentialsResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		app.recordAudit(r, "mfa.recovery_code_used", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+	}
+
+	app.recordLoginSuccess(r, user.Email)
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFA, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.startSession(r, user, true)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	message := "User logged in successfully"
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message, "user": user, "token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// show whether the user has two-factor authentication enabled
+func (app *application) GetMFAStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	required, err := app.mfaRequiredForRole(user.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"enabled": mfa.Enabled(), "required": required}
+	if mfa.Enabled() {
+		remaining, err := app.models.MFA.RemainingRecoveryCodes(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["enabled_at"] = mfa.EnabledAt
+		env["recovery_codes_remaining"] = remaining
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// start enrolling an authenticator app, returning the secret to add to it
+func (app *application) StartTOTPEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sealed, err := totp.Seal(app.config.mfa.secretKey, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.StartEnrolment(user.ID, sealed)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			v := validator.New()
+			v.AddError("mfa", "validation.mfa_enabled")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirm the enrolment with a code from the authenticator app, turning two-factor
+// authentication on and returning the recovery codes
+func (app *application) ConfirmTOTPEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "validation.mfa_enrolment")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		v.AddError("mfa", "validation.mfa_enabled")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	secret, err := totp.Open(app.config.mfa.secretKey, mfa.Secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	step, ok := totp.Validate(secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "validation.invalid")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.MFA.Enable(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			v.AddError("mfa", "validation.mfa_enabled")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	codes, err := app.models.MFA.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the user has just proven they hold the second factor, so the session they did it
+	// from counts as verified
+	if session := app.contextGetSession(r); session != nil {
+		err = app.models.Sessions.MarkMFAVerified(session)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.recordAudit(r, "mfa.enable", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	env := envelope{"message": "two-factor authentication enabled", "recovery_codes": codes}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// turn two-factor authentication off, confirmed with the password and a current code
+func (app *application) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Password != "", "password", "validation.required")
+	v.Check(input.Code != "", "code", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	required, err := app.mfaRequiredForRole(user.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if required {
+		v.AddError("mfa", "validation.mfa_required")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	match, err := user.Password.Match(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !mfa.Enabled() {
+		v.AddError("mfa", "validation.mfa_not_enabled")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	ok, err := app.verifyTOTP(mfa, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.MFA.Disable(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "mfa.disable", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replace the user's recovery codes, confirmed with a current code
+func (app *application) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !mfa.Enabled() {
+		v.AddError("mfa", "validation.mfa_not_enabled")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	ok, err := app.verifyTOTP(mfa, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	codes, err := app.models.MFA.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "mfa.recovery_codes_regenerated", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list which account roles must use two-factor authentication
+func (app *application) ListMFAPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	policies, err := app.models.MFA.GetPolicies()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"policies": policies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// require or stop requiring two-factor authentication for an account role
+func (app *application) UpdateMFAPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	var input struct {
+		Required *bool `json:"required"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateMFAPolicyRole(v, role)
+	v.Check(input.Required != nil, "required", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before, err := app.models.MFA.IsRequired(role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	policy := &data.MFAPolicy{Role: role, Required: *input.Required}
+	err = app.models.MFA.SetPolicy(policy)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidateMFAPolicies()
+
+	app.recordAudit(r, "mfa.policy_update", "mfa_policy", role, envelope{"required": before}, envelope{"required": policy.Required})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"policy": policy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// remove a user's two-factor authentication, for when they have lost their device and
+// their recovery codes. Their sessions are revoked so they have to sign in again.
+func (app *application) ResetUserMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.MFA.Disable(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "mfa.reset", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication reset successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
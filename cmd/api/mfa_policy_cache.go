@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// the Postgres channel the MFA policy trigger notifies on
+const mfaPoliciesChangedChannel = "mfa_policies_changed"
+
+// mfaPolicyCache holds which account roles must use two-factor authentication, so that
+// requireActivatedUser doesn't have to look it up on every request. There are only a
+// few roles, so they are loaded and dropped together. Like the permission cache, it is
+// cleared when the database reports a change and expires after the TTL as a backstop.
+type mfaPolicyCache struct {
+	mu       sync.RWMutex
+	ttl      time.Duration
+	required map[string]bool
+	expiry   time.Time
+}
+
+func newMFAPolicyCache(ttl time.Duration) *mfaPolicyCache {
+	return &mfaPolicyCache{ttl: ttl}
+}
+
+func (c *mfaPolicyCache) get() (map[string]bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.required == nil || time.Now().After(c.expiry) {
+		return nil, false
+	}
+	return c.required, true
+}
+
+func (c *mfaPolicyCache) set(required map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.required = required
+	c.expiry = time.Now().Add(c.ttl)
+}
+
+func (c *mfaPolicyCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.required = nil
+}
+
+// mfaRequiredForRole reports whether users with the role must use two-factor
+// authentication, from the cache when it holds a fresh copy of the policies
+func (app *application) mfaRequiredForRole(role string) (bool, error) {
+	if app.mfaPolicies == nil {
+		return app.models.MFA.IsRequired(role)
+	}
+
+	if required, ok := app.mfaPolicies.get(); ok {
+		return required[role], nil
+	}
+
+	policies, err := app.models.MFA.GetPolicies()
+	if err != nil {
+		return false, err
+	}
+
+	required := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		required[policy.Role] = policy.Required
+	}
+
+	app.mfaPolicies.set(required)
+	return required[role], nil
+}
+
+// invalidateMFAPolicies drops the cached policies straight away, rather than waiting for
+// the database notification to come back
+func (app *application) invalidateMFAPolicies() {
+	if app.mfaPolicies != nil {
+		app.mfaPolicies.invalidate()
+	}
+}
@@ -5,13 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/araromirichard/internal/data"
 	"github.com/araromirichard/internal/validator"
 	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -28,68 +25,21 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	//define a cliet struct to hold the rate limiter and the last seen of each client
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	// Declare a mutex and a map to hold the clients IP address and the Rate limiter
-	var (
-		mu sync.Mutex
-		// clients is a map that holds the client structs
-		clients = make(map[string]*client)
-	)
-
-	//lunch a background go routine that removes old entries from the map once every minute
-	go func() {
-
-		for {
-			time.Sleep(time.Minute)
-			//lock the mutex to prevent the rate limit checks from happenning while the cleanup is taking place
-			mu.Lock()
-
-			//loop through the map and check if the last seen time is older than 3 minutes
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-
-			}
-
-			//unlock themutex when the cleanup is done
-			mu.Unlock()
-
-		}
-	}()
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			// Use the realip.FromRequest() function to get the client's real IP address.
-			ip := realip.FromRequest(r)
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
-			mu.Unlock()
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+		// Service integrations authenticate with an API key in their own header
+		// rather than a bearer token.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			r, ok := app.authenticateAPIKey(w, r, apiKey)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
 		// Get the authorization header from the request
 		authorizationHeader := r.Header.Get("Authorization")
 		// If the header is empty, we return a 401 Unauthorized response
@@ -124,6 +74,27 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Tokens belonging to a suspended account are rejected straight away, even
+		// though they have not expired yet.
+		if user.IsSuspended() {
+			app.accountSuspendedResponse(w, r)
+			return
+		}
+		// Look up the session the token belongs to and record that it was used, so it
+		// shows up with the right activity in the user's list of devices.
+		session, err := app.models.Sessions.GetForToken(data.ScopeAuthentication, token)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if session != nil {
+			err = app.models.Sessions.Touch(session, realip.FromRequest(r), r.UserAgent())
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			r = app.contextSetSession(r, session)
+		}
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
@@ -133,8 +104,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 }
 
 // Create a new requireAuthenticatedUser() middleware to check that a user is not
-// anonymous.
+// anonymous. API keys are refused: they can only be used on routes behind
+// requirePermission, where their scopes apply.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.denyAPIKey(app.authenticatedUser(next))
+}
+
+// Checks that a user is both authenticated and activated. API keys are refused, as in
+// requireAuthenticatedUser.
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.denyAPIKey(app.activatedUser(next))
+}
+
+// authenticatedUser checks that a user is not anonymous
+func (app *application) authenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 		if user.IsAnonymous() {
@@ -145,8 +128,21 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
-// Checks that a user is both authenticated and activated.
-func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+// denyAPIKey refuses requests authenticated with an API key. A key's scopes are only
+// checked by requirePermission, so a route that doesn't check a permission would
+// otherwise be open to any key.
+func (app *application) denyAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// activatedUser checks that a user is both authenticated and activated
+func (app *application) activatedUser(next http.HandlerFunc) http.HandlerFunc {
 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -155,19 +151,29 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 			app.inactiveAccountResponse(w, r)
 			return
 		}
+		// Check that the user has signed in with a second factor if their role
+		// requires one.
+		required, err := app.mfaRequired(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if required {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
-	// Wrap fn with the requireAuthenticatedUser() middleware before returning it.
-	return app.requireAuthenticatedUser(fn)
+	// Wrap fn with the authenticatedUser() middleware before returning it.
+	return app.authenticatedUser(fn)
 }
 
 // middleware to require permission
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Get the slice of permissions for the user in the request context. They are
+		// loaded at most once per request and come from the cache when possible.
+		permissions, err := app.contextGetPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -182,8 +188,10 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		// the chain.
 		next.ServeHTTP(w, r)
 	}
-	// Wrap this with the requireActivatedUser() middleware before returning it.
-	return app.requireActivatedUser(fn)
+	// Wrap this with the activatedUser() middleware before returning it. Unlike
+	// requireActivatedUser, it lets API keys through, since their scopes are the
+	// permissions checked here.
+	return app.activatedUser(fn)
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
@@ -196,6 +204,8 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// Let the frontend see how much of its rate limit is left.
+					w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
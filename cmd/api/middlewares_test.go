@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/araromirichard/internal/data"
+)
+
+func TestSelfServiceRoutesRefuseAPIKeys(t *testing.T) {
+	app := &application{}
+	serviceAccount := &data.User{ID: 1, Activated: true}
+	key := &data.APIKey{ID: 1, Permissions: data.Permissions{}}
+
+	middlewares := map[string]func(http.HandlerFunc) http.HandlerFunc{
+		"requireAuthenticatedUser": app.requireAuthenticatedUser,
+		"requireActivatedUser":     app.requireActivatedUser,
+	}
+
+	for name, middleware := range middlewares {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			handler := middleware(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/users/me/erasure", nil)
+			r = app.contextSetAPIKey(r, serviceAccount, key)
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if called {
+				t.Error("handler was called for an API key")
+			}
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
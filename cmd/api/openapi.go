@@ -0,0 +1,1429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+)
+
+// schema is a JSON Schema object as used by OpenAPI 3
+type schema map[string]interface{}
+
+func str() schema      { return schema{"type": "string"} }
+func integer() schema  { return schema{"type": "integer", "format": "int64"} }
+func number() schema   { return schema{"type": "number"} }
+func boolean() schema  { return schema{"type": "boolean"} }
+func dateTime() schema { return schema{"type": "string", "format": "date-time"} }
+func date() schema     { return schema{"type": "string", "format": "date"} }
+func binary() schema   { return schema{"type": "string", "format": "binary"} }
+
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+func oneOf(values ...string) schema {
+	return schema{"type": "string", "enum": values}
+}
+
+// with returns a copy of the schema with an extra keyword, such as a description
+func (s schema) with(key string, value interface{}) schema {
+	out := make(schema, len(s)+1)
+	for k, v := range s {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// property is one field of an object schema
+type property struct {
+	name     string
+	schema   schema
+	required bool
+}
+
+func field(name string, s schema) property {
+	return property{name: name, schema: s}
+}
+
+func requiredField(name string, s schema) property {
+	return property{name: name, schema: s, required: true}
+}
+
+func object(props ...property) schema {
+	properties := make(schema, len(props))
+	var required []string
+	for _, p := range props {
+		properties[p.name] = p.schema
+		if p.required {
+			required = append(required, p.name)
+		}
+	}
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// messageSchema is the envelope most handlers reply with when there is nothing else to return
+func messageSchema() schema {
+	return object(requiredField("message", str()))
+}
+
+// schemaRegistry turns the data package's types into schemas by reading their json tags,
+// so the document can't drift from what the handlers actually send. Each struct becomes
+// a component that the operations refer to.
+type schemaRegistry struct {
+	components schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{components: schema{}}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// model returns a reference to the component for v's type, adding it if it's new
+func (sr *schemaRegistry) model(v interface{}) schema {
+	return sr.schemaFor(reflect.TypeOf(v))
+}
+
+func (sr *schemaRegistry) schemaFor(t reflect.Type) schema {
+	switch {
+	case t == timeType:
+		return dateTime()
+	case t == rawMessageType:
+		return schema{"description": "any JSON value"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem := sr.schemaFor(t.Elem())
+		if _, isRef := elem["$ref"]; isRef {
+			// keywords next to a $ref are ignored, so the reference has to be wrapped
+			return schema{"allOf": []schema{elem}, "nullable": true}
+		}
+		return elem.with("nullable", true)
+	case reflect.String:
+		return str()
+	case reflect.Bool:
+		return boolean()
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return integer()
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Float32, reflect.Float64:
+		return number()
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		return arrayOf(sr.schemaFor(t.Elem()))
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": sr.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return object(sr.properties(t)...)
+		}
+		if _, found := sr.components[t.Name()]; !found {
+			// reserve the name first, so types that refer to themselves terminate
+			sr.components[t.Name()] = schema{}
+			sr.components[t.Name()] = object(sr.properties(t)...)
+		}
+		return schema{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return schema{}
+	}
+}
+
+// properties lists the fields encoding/json would write for a struct. Fields without
+// omitempty are always present, so they are marked as required.
+func (sr *schemaRegistry) properties(t reflect.Type) []property {
+	var props []property
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				props = append(props, sr.properties(embedded)...)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props = append(props, property{
+			name:     name,
+			schema:   sr.schemaFor(f.Type),
+			required: !strings.Contains(opts, "omitempty"),
+		})
+	}
+	return props
+}
+
+// access says who may call a route, following the middleware that wraps its handler
+type access int
+
+const (
+	accessPublic        access = iota
+	accessAuthenticated        // requireAuthenticatedUser
+	accessActivated            // requireActivatedUser
+	accessAdmin                // requirePermission("admin:access")
+	accessTutor                // requireTutorPolicy
+)
+
+// parameter is a path or query parameter
+type parameter struct {
+	name        string
+	in          string
+	description string
+	required    bool
+	schema      schema
+}
+
+func queryParam(name string, s schema, description string) parameter {
+	return parameter{name: name, in: "query", description: description, schema: s}
+}
+
+func requiredQueryParam(name string, s schema, description string) parameter {
+	return parameter{name: name, in: "query", description: description, required: true, schema: s}
+}
+
+func pathParam(name string, s schema, description string) parameter {
+	return parameter{name: name, in: "path", description: description, required: true, schema: s}
+}
+
+// searchTerm is the free text search accepted by the user listings
+var searchTerm = queryParam("search_term", str(), "Matches the email, first name, last name or username.")
+
+// filterParams are the page, page_size and sort parameters read into data.Filters. sortable is
+// the handler's SortSafeList, without the descending variants.
+func filterParams(pageSize int, defaultSort string, sortable ...string) []parameter {
+	sorts := make([]string, 0, len(sortable)*2)
+	for _, s := range sortable {
+		sorts = append(sorts, s, "-"+s)
+	}
+
+	return []parameter{
+		queryParam("page", integer().with("minimum", 1).with("maximum", 10_000_000).with("default", 1), "Page number."),
+		queryParam("page_size", integer().with("minimum", 1).with("maximum", 100).with("default", pageSize), "Results per page."),
+		queryParam("sort", oneOf(sorts...).with("default", defaultSort), "Field to sort by, prefixed with - for descending order."),
+	}
+}
+
+// tutorID is the tutor's ivw_id, which the tutor routes take in place of a numeric id
+var tutorID = pathParam("id", str(), "The tutor's ivw_id.")
+
+// emailTemplateName and emailTemplateLocale pick the email template the admin routes
+// work on
+var (
+	emailTemplateName   = pathParam("name", str(), "The embedded template, such as tutor_welcome.tmpl.")
+	emailTemplateLocale = queryParam("locale", oneOf("en", "fr").with("default", "en"), "")
+)
+
+// operation documents one route
+type operation struct {
+	summary     string
+	description string
+	access      access
+	params      []parameter
+	body        schema
+	bodyType    string // defaults to application/json
+	status      int
+	response    schema
+	contentType string // defaults to application/json
+	errors      []int  // errors on top of the ones that follow from the route's middleware
+}
+
+// apiOperations documents every route, keyed by method and path as they are registered
+// in routes(). A route that is added without being documented here fails the tests, see
+// openapi_test.go.
+func apiOperations(sr *schemaRegistry) map[string]operation {
+	tokens := object(
+		requiredField("authentication_token", sr.model(data.Token{})),
+		requiredField("refresh_token", sr.model(data.Token{})),
+	)
+	login := object(
+		requiredField("message", str()),
+		requiredField("user", sr.model(data.User{})),
+		requiredField("token", sr.model(data.Token{})),
+		requiredField("refresh_token", sr.model(data.Token{})),
+	)
+	mfaChallenge := object(
+		requiredField("mfa_required", boolean()),
+		requiredField("mfa_token", str().with("description", "Pass to POST /v1/auth/login/mfa along with a code.")),
+	)
+	email := object(requiredField("email", str().with("format", "email")))
+	token := object(requiredField("token", str().with("description", "The 26 character token sent by email.")))
+	code := object(requiredField("code", str().with("description", "A six digit code from the authenticator app.")))
+	users := object(
+		requiredField("users", arrayOf(sr.model(data.User{}))),
+		requiredField("metadata", sr.model(data.Metadata{})),
+	)
+	userProfile := []property{
+		field("date_of_birth", date()),
+		field("gender", str()),
+		field("street_address_1", str()),
+		field("street_address_2", str()),
+		field("city", str()),
+		field("state", str()),
+		field("country", str().with("description", "An ISO 3166-1 alpha-2 country code, such as NG.")),
+		field("zipcode", str()),
+	}
+
+	return map[string]operation{
+		// health and operations
+		"GET /v1/healthcheck": {
+			summary: "Report the API's status and version",
+			status:  http.StatusOK,
+			response: object(
+				requiredField("status", str()),
+				requiredField("system_info", object(requiredField("environment", str()), requiredField("version", str()))),
+			),
+		},
+		"GET /v1/health/live": {
+			summary:     "Liveness check",
+			description: "Succeeds while the process is serving requests. Dependencies are not checked.",
+			status:      http.StatusOK,
+			response:    object(requiredField("status", oneOf("alive"))),
+		},
+		"GET /v1/health/ready": {
+			summary:     "Readiness check",
+			description: "Checks the database. Fails with 503 when it is down or the server is shutting down. The errors, connection pool stats and optional dependency probes are only served at /health/ready on the internal metrics port.",
+			status:      http.StatusOK,
+			response: object(
+				requiredField("status", oneOf("ready", "unavailable", "shutting_down")),
+				field("checks", schema{"type": "object", "additionalProperties": object(
+					requiredField("status", oneOf("up", "down")),
+					requiredField("required", boolean()),
+					requiredField("latency_ms", number()),
+				)}),
+			),
+			errors: []int{http.StatusServiceUnavailable},
+		},
+		"GET /v1/openapi.json": {
+			summary:     "This OpenAPI document",
+			status:      http.StatusOK,
+			response:    schema{"type": "object"},
+			contentType: "application/json",
+		},
+
+		// authentication
+		"GET /v1/users-by-role": {
+			summary: "List users with a role",
+			params: append([]parameter{
+				requiredQueryParam("role", str(), "The account role, such as tutor or student."),
+				searchTerm,
+			}, filterParams(20, "id", "id", "first_name", "last_name", "email")...),
+			status:   http.StatusOK,
+			response: users,
+			errors:   []int{http.StatusBadRequest},
+		},
+		"POST /v1/auth/register": {
+			summary:     "Register a new user",
+			description: "Creates an account that has to be activated with the token sent by email.",
+			body: object(append([]property{
+				requiredField("first_name", str()),
+				requiredField("last_name", str()),
+				requiredField("username", str()),
+				requiredField("email", str().with("format", "email")),
+				requiredField("password", str().with("minLength", 8).with("maxLength", 72)),
+				requiredField("role", oneOf("tutor", "student")),
+				field("about_yourself", str()),
+				field("address", sr.model(data.Address{})),
+				field("guardian", sr.model(data.Guardian{})),
+				field("student", sr.model(data.Student{})),
+				field("photo", sr.model(data.UserPhoto{})),
+			}, userProfile[:2]...)...),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+			errors:   []int{http.StatusConflict},
+		},
+		"POST /v1/auth/login": {
+			summary:     "Log in with an email address and password",
+			description: "Accounts with two-factor authentication get an mfa_token to complete the login with instead of tokens.",
+			body: object(
+				requiredField("email", str().with("format", "email")),
+				requiredField("password", str()),
+			),
+			status:   http.StatusOK,
+			response: schema{"oneOf": []schema{login, mfaChallenge}},
+			errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+		},
+		"POST /v1/auth/login/mfa": {
+			summary: "Complete a login with a two-factor code or a recovery code",
+			body: object(
+				requiredField("mfa_token", str()),
+				field("code", str()),
+				field("recovery_code", str()),
+			),
+			status:   http.StatusOK,
+			response: login,
+			errors:   []int{http.StatusUnauthorized},
+		},
+		"POST /v1/auth/magic-link": {
+			summary:     "Email a login link",
+			description: "Always succeeds, so it can't be used to find out which addresses have accounts.",
+			body:        email,
+			status:      http.StatusAccepted,
+			response:    messageSchema(),
+		},
+		"GET /v1/auth/verify-email-token": {
+			summary:  "Resend the activation email",
+			body:     email,
+			status:   http.StatusOK,
+			response: messageSchema(),
+			errors:   []int{http.StatusNotFound},
+		},
+		"POST /v1/auth/forgot-password": {
+			summary:     "Email a password reset token",
+			description: "Always succeeds, so it can't be used to find out which addresses have accounts.",
+			body:        email,
+			status:      http.StatusAccepted,
+			response:    messageSchema(),
+		},
+		"POST /v1/auth/reset-password": {
+			summary: "Reset a password with the token sent by email",
+			body: object(
+				requiredField("token", str()),
+				requiredField("new_password", str().with("minLength", 8).with("maxLength", 72)),
+				requiredField("confirm_password", str()),
+			),
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"POST /v1/auth/logout": {
+			summary:  "End the current session",
+			access:   accessAuthenticated,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+
+		// sessions
+		"GET /v1/auth/sessions": {
+			summary: "List the user's sessions",
+			access:  accessAuthenticated,
+			status:  http.StatusOK,
+			response: object(requiredField("sessions", arrayOf(schema{"allOf": []schema{
+				sr.model(data.Session{}),
+				object(requiredField("current", boolean().with("description", "Whether this is the session making the request."))),
+			}}))),
+		},
+		"DELETE /v1/auth/sessions": {
+			summary:  "End all of the user's sessions",
+			access:   accessAuthenticated,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"DELETE /v1/auth/sessions/:id": {
+			summary:  "End one of the user's sessions",
+			access:   accessAuthenticated,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+
+		// two-factor authentication
+		"GET /v1/auth/mfa": {
+			summary: "Show the user's two-factor authentication status",
+			access:  accessAuthenticated,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("enabled", boolean()),
+				requiredField("required", boolean()),
+				field("enabled_at", dateTime()),
+				field("recovery_codes_remaining", integer()),
+			),
+		},
+		"POST /v1/auth/mfa/totp": {
+			summary:     "Start enrolling an authenticator app",
+			description: "The enrolment only takes effect once it is confirmed with a code from the app.",
+			access:      accessAuthenticated,
+			status:      http.StatusCreated,
+			response: object(
+				requiredField("secret", str()),
+				requiredField("otpauth_uri", str().with("format", "uri")),
+			),
+			errors: []int{http.StatusConflict},
+		},
+		"POST /v1/auth/mfa/totp/confirm": {
+			summary: "Confirm the authenticator app enrolment",
+			access:  accessAuthenticated,
+			body:    code,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("message", str()),
+				requiredField("recovery_codes", arrayOf(str())),
+			),
+			errors: []int{http.StatusNotFound, http.StatusConflict},
+		},
+		"DELETE /v1/auth/mfa/totp": {
+			summary: "Turn off two-factor authentication",
+			access:  accessAuthenticated,
+			body: object(
+				requiredField("password", str()),
+				requiredField("code", str()),
+			),
+			status:   http.StatusOK,
+			response: messageSchema(),
+			errors:   []int{http.StatusForbidden, http.StatusNotFound},
+		},
+		"POST /v1/auth/mfa/recovery-codes": {
+			summary:     "Replace the recovery codes",
+			description: "The previous codes stop working.",
+			access:      accessAuthenticated,
+			body:        code,
+			status:      http.StatusOK,
+			response:    object(requiredField("recovery_codes", arrayOf(str()))),
+			errors:      []int{http.StatusNotFound},
+		},
+
+		// users
+		"GET /v1/auth/whoami": {
+			summary: "Show the authenticated user",
+			access:  accessActivated,
+			status:  http.StatusOK,
+			response: object(requiredField("user", object(
+				requiredField("id", integer()),
+				requiredField("name", str()),
+				requiredField("email", str()),
+				requiredField("role", str()),
+				requiredField("activated", boolean()),
+			))),
+		},
+		"GET /v1/users": {
+			summary: "List users",
+			access:  accessAdmin,
+			params: append([]parameter{
+				searchTerm,
+				queryParam("class_preferences", str(), "Comma separated class preferences to filter by."),
+				queryParam("activated", boolean(), "Filter by activation status."),
+			}, filterParams(10, "id", "id", "first_name", "last_name", "email", "role", "city", "state", "country", "activated")...),
+			status: http.StatusOK,
+			response: object(
+				requiredField("message", str()),
+				requiredField("users", arrayOf(sr.model(data.User{}))),
+				requiredField("metadata", sr.model(data.Metadata{})),
+			),
+		},
+		"PUT /v1/users/activate": {
+			summary:  "Activate an account with the token sent by email",
+			body:     token,
+			status:   http.StatusOK,
+			response: object(requiredField("user", sr.model(data.User{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"POST /v1/photo-upload": {
+			summary:  "Upload a photo to Cloudinary",
+			body:     object(requiredField("user_photo", binary())),
+			bodyType: "multipart/form-data",
+			status:   http.StatusOK,
+			response: object(
+				requiredField("uploadUrl", str().with("format", "uri")),
+				requiredField("publicId", str()),
+			),
+			errors: []int{http.StatusBadRequest},
+		},
+		"POST /v1/users/photo": {
+			summary: "Save an uploaded photo against a user",
+			body: object(
+				requiredField("user_id", integer()),
+				requiredField("photo_url", str().with("format", "uri")),
+				requiredField("public_id", str()),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("user_photo", sr.model(data.UserPhoto{}))),
+		},
+		"PUT /v1/users/photo/:id": {
+			summary:  "Replace a user's photo",
+			params:   []parameter{pathParam("id", integer(), "The user's id.")},
+			body:     object(requiredField("user_photo", binary())),
+			bodyType: "multipart/form-data",
+			status:   http.StatusOK,
+			response: messageSchema(),
+			errors:   []int{http.StatusConflict},
+		},
+		"PUT /v1/users/me/password": {
+			summary: "Change the password",
+			access:  accessActivated,
+			body: object(
+				requiredField("current_password", str()),
+				requiredField("new_password", str().with("minLength", 8).with("maxLength", 72)),
+				requiredField("confirm_password", str()),
+			),
+			status:   http.StatusOK,
+			response: messageSchema(),
+			errors:   []int{http.StatusConflict},
+		},
+		"PUT /v1/users/me/email": {
+			summary:     "Change the email address",
+			description: "A confirmation link is sent to the new address, and a link to undo the change to the old one.",
+			access:      accessActivated,
+			body: object(
+				requiredField("email", str().with("format", "email")),
+				requiredField("password", str()),
+			),
+			status:   http.StatusAccepted,
+			response: messageSchema(),
+		},
+		"PUT /v1/users/me/locale": {
+			summary:     "Set the preferred language",
+			description: "Emails and API messages are sent in this language. An empty locale goes back to following the Accept-Language header.",
+			access:      accessActivated,
+			body: object(
+				requiredField("locale", oneOf("", "en", "fr")),
+			),
+			status:   http.StatusOK,
+			response: object(requiredField("user", sr.model(data.User{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"PUT /v1/users/email/confirm": {
+			summary:  "Confirm an email address change",
+			body:     token,
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("email", str())),
+			errors:   []int{http.StatusConflict},
+		},
+		"PUT /v1/users/email/revert": {
+			summary:     "Undo an email address change",
+			description: "Signs the user out everywhere.",
+			body:        token,
+			status:      http.StatusOK,
+			response:    messageSchema(),
+			errors:      []int{http.StatusConflict},
+		},
+		"POST /v1/users/me/export": {
+			summary:     "Request an export of the user's data",
+			description: "The export is prepared in the background and a download link is emailed to the user.",
+			access:      accessActivated,
+			status:      http.StatusAccepted,
+			response: object(
+				requiredField("message", str()),
+				field("export", sr.model(data.DataExport{})),
+			),
+		},
+		"GET /v1/users/me/export/download": {
+			summary:     "Download a data export",
+			params:      []parameter{requiredQueryParam("token", str(), "The download token from the email.")},
+			status:      http.StatusOK,
+			response:    sr.model(data.UserDataArchive{}),
+			contentType: "application/json",
+			errors:      []int{http.StatusNotFound},
+		},
+		"POST /v1/users/me/erasure": {
+			summary:     "Schedule the account for erasure",
+			description: "The account is erased once the grace period has passed, unless the request is cancelled.",
+			access:      accessActivated,
+			body:        object(requiredField("password", str())),
+			status:      http.StatusAccepted,
+			response: object(
+				requiredField("message", str()),
+				requiredField("erasure", sr.model(data.AccountErasure{})),
+			),
+			errors: []int{http.StatusConflict},
+		},
+		"DELETE /v1/users/me/erasure": {
+			summary: "Cancel a scheduled erasure",
+			access:  accessActivated,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("message", str()),
+				requiredField("erasure", sr.model(data.AccountErasure{})),
+			),
+			errors: []int{http.StatusNotFound},
+		},
+
+		// tokens
+		"POST /v1/tokens/authentication": {
+			summary: "Create an authentication token",
+			body: object(
+				requiredField("email", str().with("format", "email")),
+				requiredField("password", str()),
+			),
+			status:   http.StatusCreated,
+			response: tokens,
+			errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+		},
+		"POST /v1/tokens/refresh": {
+			summary:     "Swap a refresh token for new tokens",
+			description: "Refresh tokens can only be used once. Using one again revokes the session.",
+			body:        object(requiredField("refresh_token", str())),
+			status:      http.StatusCreated,
+			response:    tokens,
+			errors:      []int{http.StatusUnauthorized},
+		},
+		"POST /v1/tokens/magic-link": {
+			summary:  "Swap a login link token for authentication tokens",
+			body:     token,
+			status:   http.StatusCreated,
+			response: tokens,
+			errors:   []int{http.StatusForbidden},
+		},
+		"POST /v1/tokens/activation": {
+			summary:  "Email a new activation token",
+			body:     email,
+			status:   http.StatusAccepted,
+			response: messageSchema(),
+		},
+
+		// tutors
+		"POST /v1/tutors": {
+			summary: "Create the authenticated tutor's profile",
+			access:  accessActivated,
+			body: object(
+				requiredField("rate_per_hour", number()),
+				field("eligible_to_work", boolean()),
+				field("criminal_record", boolean()),
+				requiredField("timezone", str()),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("message", str()), requiredField("tutor_id", str())),
+			errors:   []int{http.StatusNotFound},
+		},
+		"GET /v1/tutors/:id": {
+			summary:  "Show a tutor",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("tutor", sr.model(data.Tutor{}))),
+		},
+		"PATCH /v1/tutors/:id": {
+			summary: "Update a tutor",
+			access:  accessTutor,
+			params:  []parameter{tutorID},
+			body: object(
+				field("rate_per_hour", number()),
+				field("eligible_to_work", boolean()),
+				field("criminal_record", boolean()),
+				field("timezone", str()),
+			),
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("tutor_id", str())),
+			errors:   []int{http.StatusConflict},
+		},
+		"DELETE /v1/tutors/:id": {
+			summary:  "Delete a tutor",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"POST /v1/tutors/:id/education": {
+			summary: "Add to a tutor's education",
+			access:  accessTutor,
+			params:  []parameter{tutorID},
+			body: object(
+				requiredField("institute", str()),
+				requiredField("course", str()),
+				requiredField("start_year", integer()),
+				requiredField("end_year", integer()),
+			),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+		},
+		"GET /v1/tutors/:id/education": {
+			summary:  "List a tutor's education",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("education", arrayOf(sr.model(data.Education{})))),
+		},
+		"POST /v1/tutors/:id/languages": {
+			summary:  "Add languages a tutor speaks",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			body:     object(requiredField("languages", arrayOf(str()))),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+		},
+		"GET /v1/tutors/:id/languages": {
+			summary:  "List the languages a tutor speaks",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("tutor_languages", arrayOf(str()))),
+		},
+		"POST /v1/tutors/:id/schedules": {
+			summary: "Add to a tutor's schedule",
+			access:  accessTutor,
+			params:  []parameter{tutorID},
+			body: object(
+				requiredField("day", str()),
+				requiredField("start_time", dateTime()),
+				requiredField("end_time", dateTime()),
+			),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+		},
+		"GET /v1/tutors/:id/schedules": {
+			summary:  "List a tutor's schedule",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("schedules", arrayOf(sr.model(data.Schedule{})))),
+		},
+		"POST /v1/tutors/:id/employments": {
+			summary: "Add to a tutor's employment history",
+			access:  accessTutor,
+			params:  []parameter{tutorID},
+			body: object(
+				requiredField("company", str()),
+				requiredField("position", str()),
+				requiredField("start_date", dateTime()),
+				requiredField("end_date", dateTime()),
+			),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+		},
+		"GET /v1/tutors/:id/employments": {
+			summary:  "List a tutor's employment history",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("employment_history", arrayOf(sr.model(data.EmploymentHistory{})))),
+		},
+		"POST /v1/tutors/:id/skills": {
+			summary:  "Add skills to a tutor",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			body:     object(requiredField("skills", arrayOf(str()))),
+			status:   http.StatusCreated,
+			response: messageSchema(),
+		},
+		"GET /v1/tutors/:id/skills": {
+			summary:  "List a tutor's skills",
+			access:   accessTutor,
+			params:   []parameter{tutorID},
+			status:   http.StatusOK,
+			response: object(requiredField("skill", arrayOf(str()))),
+		},
+
+		// admin
+		"PATCH /v1/admin/tutors/:id": {
+			summary:  "Verify a tutor",
+			access:   accessAdmin,
+			params:   []parameter{tutorID},
+			body:     object(requiredField("ivw_id", str())),
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"GET /v1/admin/audit": {
+			summary: "List audit events",
+			access:  accessAdmin,
+			params: append([]parameter{
+				queryParam("actor_id", integer(), "Only events by this user."),
+				queryParam("entity_type", str(), "Only events on this type of entity, such as user."),
+				queryParam("entity_id", str(), "Only events on this entity. Needs entity_type."),
+				queryParam("from", date(), "Only events on or after this day."),
+				queryParam("to", date(), "Only events on or before this day."),
+			}, filterParams(20, "-created_at", "id", "created_at", "action")...),
+			status: http.StatusOK,
+			response: object(
+				requiredField("audit_events", arrayOf(sr.model(data.AuditEvent{}))),
+				requiredField("metadata", sr.model(data.Metadata{})),
+			),
+		},
+		"GET /v1/admin/stats": {
+			summary: "Dashboard statistics",
+			description: "Signups, activation and tutor verification over a date range, and the skills offered " +
+				"by the most tutors. Bookings, revenue and searched skills are not reported yet.",
+			access: accessAdmin,
+			params: []parameter{
+				queryParam("granularity", oneOf(data.StatsGranularities...).with("default", "day"), "How signups are grouped."),
+				queryParam("from", date(), "Start of the range. Defaults to 30 days ago."),
+				queryParam("to", date(), "End of the range, inclusive. Defaults to today."),
+			},
+			status: http.StatusOK,
+			response: object(requiredField("stats", object(
+				requiredField("range", sr.model(data.StatsRange{})),
+				requiredField("signups", arrayOf(sr.model(data.SignupCount{}))),
+				requiredField("activation", sr.model(data.ActivationStats{})),
+				requiredField("tutors_by_verification", sr.model(data.VerificationStats{})),
+				requiredField("top_offered_skills", arrayOf(sr.model(data.SkillCount{}))),
+			))),
+		},
+		"GET /v1/admin/users/:id": {
+			summary:  "Show a user",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("user", sr.model(data.User{}))),
+		},
+		"PATCH /v1/admin/users/:id": {
+			summary: "Update a user",
+			access:  accessAdmin,
+			body: object(append([]property{
+				field("first_name", str()),
+				field("last_name", str()),
+				field("username", str()),
+				field("email", str().with("format", "email")),
+				field("role", str()),
+			}, userProfile...)...),
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("user", sr.model(data.User{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"DELETE /v1/admin/users/:id": {
+			summary:  "Delete a user",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"POST /v1/admin/users/:id/suspend": {
+			summary:     "Suspend a user",
+			description: "Signs the user out everywhere. Without until, the suspension lasts until it is lifted.",
+			access:      accessAdmin,
+			body: object(
+				requiredField("reason", str()),
+				field("until", dateTime()),
+			),
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("user", sr.model(data.User{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"POST /v1/admin/users/:id/unsuspend": {
+			summary:  "Lift a user's suspension",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("user", sr.model(data.User{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"POST /v1/admin/users/:id/unlock": {
+			summary:     "Unlock a user's account",
+			description: "Clears the failed login attempts that locked it.",
+			access:      accessAdmin,
+			status:      http.StatusOK,
+			response:    messageSchema(),
+		},
+		"DELETE /v1/admin/users/:id/mfa": {
+			summary:  "Reset a user's two-factor authentication",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"GET /v1/admin/users/:id/permissions": {
+			summary: "Show a user's permissions and where they come from",
+			access:  accessAdmin,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("user_id", integer()),
+				requiredField("permissions", arrayOf(str()).with("description", "Effective permissions.")),
+				requiredField("direct", arrayOf(str()).with("description", "Permissions granted to the user directly.")),
+				requiredField("roles", arrayOf(sr.model(data.Role{}))),
+			),
+		},
+		"POST /v1/admin/users/:id/permissions": {
+			summary:  "Grant permissions to a user",
+			access:   accessAdmin,
+			body:     object(requiredField("permissions", arrayOf(str()))),
+			status:   http.StatusOK,
+			response: object(requiredField("user_id", integer()), requiredField("direct", arrayOf(str()))),
+		},
+		"DELETE /v1/admin/users/:id/permissions/:code": {
+			summary:  "Revoke a permission granted to a user directly",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("user_id", integer()), requiredField("direct", arrayOf(str()))),
+		},
+		"POST /v1/admin/users/:id/roles": {
+			summary:  "Assign a role to a user",
+			access:   accessAdmin,
+			body:     object(requiredField("role_id", integer())),
+			status:   http.StatusOK,
+			response: object(requiredField("message", str()), requiredField("role", sr.model(data.Role{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"DELETE /v1/admin/users/:id/roles/:role_id": {
+			summary:  "Remove a role from a user",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"GET /v1/admin/roles": {
+			summary:  "List roles",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("roles", arrayOf(sr.model(data.Role{})))),
+		},
+		"POST /v1/admin/roles": {
+			summary: "Create a role",
+			access:  accessAdmin,
+			body: object(
+				requiredField("name", str()),
+				field("description", str()),
+				requiredField("permissions", arrayOf(str())),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("role", sr.model(data.Role{}))),
+		},
+		"GET /v1/admin/roles/:id": {
+			summary:  "Show a role",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("role", sr.model(data.Role{}))),
+		},
+		"PATCH /v1/admin/roles/:id": {
+			summary: "Update a role",
+			access:  accessAdmin,
+			body: object(
+				field("name", str()),
+				field("description", str()),
+				field("permissions", arrayOf(str()).with("description", "Replaces the role's permissions.")),
+			),
+			status:   http.StatusOK,
+			response: object(requiredField("role", sr.model(data.Role{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"DELETE /v1/admin/roles/:id": {
+			summary:  "Delete a role",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"GET /v1/admin/roles/:id/users": {
+			summary: "List the users a role is assigned to",
+			access:  accessAdmin,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("role", sr.model(data.Role{})),
+				requiredField("users", arrayOf(sr.model(data.RoleMember{}))),
+			),
+		},
+		"GET /v1/admin/mfa/policies": {
+			summary:  "List the two-factor authentication policies",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("policies", arrayOf(sr.model(data.MFAPolicy{})))),
+		},
+		"PUT /v1/admin/mfa/policies/:role": {
+			summary:  "Require two-factor authentication for an account role, or stop requiring it",
+			access:   accessAdmin,
+			params:   []parameter{pathParam("role", oneOf(data.MFAPolicyRoles...), "The account role.")},
+			body:     object(requiredField("required", boolean())),
+			status:   http.StatusOK,
+			response: object(requiredField("policy", sr.model(data.MFAPolicy{}))),
+		},
+		"GET /v1/admin/service-accounts": {
+			summary:  "List service accounts",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("service_accounts", arrayOf(sr.model(data.ServiceAccount{})))),
+		},
+		"POST /v1/admin/service-accounts": {
+			summary: "Create a service account",
+			access:  accessAdmin,
+			body: object(
+				requiredField("name", str()),
+				field("description", str()),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("service_account", sr.model(data.ServiceAccount{}))),
+		},
+		"DELETE /v1/admin/service-accounts/:id": {
+			summary:     "Delete a service account",
+			description: "Its API keys stop working straight away.",
+			access:      accessAdmin,
+			status:      http.StatusOK,
+			response:    messageSchema(),
+		},
+		"GET /v1/admin/service-accounts/:id/keys": {
+			summary:  "List a service account's API keys",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("api_keys", arrayOf(sr.model(data.APIKey{})))),
+		},
+		"POST /v1/admin/service-accounts/:id/keys": {
+			summary:     "Create an API key",
+			description: "The key itself is only included in this response.",
+			access:      accessAdmin,
+			body: object(
+				requiredField("name", str()),
+				requiredField("permissions", arrayOf(str())),
+				field("allowed_ips", arrayOf(str()).with("description", "Addresses or CIDR ranges the key may be used from. Empty allows any.")),
+				field("expires_at", dateTime()),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("api_key", sr.model(data.APIKey{}))),
+		},
+		"POST /v1/admin/service-accounts/:id/keys/:key_id/rotate": {
+			summary:     "Replace an API key",
+			description: "The old key keeps working for the grace period, if one is given.",
+			access:      accessAdmin,
+			body:        object(field("grace_period", str().with("description", "A duration such as 24h, at most 168h."))),
+			status:      http.StatusCreated,
+			response: object(
+				requiredField("api_key", sr.model(data.APIKey{})),
+				requiredField("previous_api_key", sr.model(data.APIKey{})),
+			),
+			errors: []int{http.StatusConflict},
+		},
+		"DELETE /v1/admin/service-accounts/:id/keys/:key_id": {
+			summary:  "Revoke an API key",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: messageSchema(),
+		},
+		"GET /v1/admin/log-level": {
+			summary:  "Show the log level",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("level", str())),
+		},
+		"PUT /v1/admin/log-level": {
+			summary:     "Change the log level",
+			description: "Only affects this instance, until it restarts.",
+			access:      accessAdmin,
+			body:        object(requiredField("level", oneOf("debug", "info", "warn", "error", "fatal", "off"))),
+			status:      http.StatusOK,
+			response:    object(requiredField("level", str())),
+		},
+		"GET /v1/admin/permissions": {
+			summary:  "List the permissions that can be granted",
+			access:   accessAdmin,
+			status:   http.StatusOK,
+			response: object(requiredField("permissions", arrayOf(str()))),
+		},
+		"GET /v1/admin/permissions/:code/users": {
+			summary: "List the users who hold a permission",
+			access:  accessAdmin,
+			status:  http.StatusOK,
+			response: object(
+				requiredField("permission", str()),
+				requiredField("users", arrayOf(sr.model(data.PermissionHolder{}))),
+			),
+		},
+		"GET /v1/admin/email-templates": {
+			summary:     "List the email templates",
+			description: "Lists every embedded template with the saved versions currently used in its place.",
+			access:      accessAdmin,
+			status:      http.StatusOK,
+			response: object(requiredField("email_templates", arrayOf(object(
+				requiredField("name", str()),
+				requiredField("overrides", arrayOf(sr.model(data.EmailTemplate{}))),
+			)))),
+		},
+		"DELETE /v1/admin/email-templates/:name": {
+			summary:     "Go back to the embedded template",
+			description: "Stops using saved versions of the template in the locale. They are kept and can be activated again.",
+			access:      accessAdmin,
+			params:      []parameter{emailTemplateName, emailTemplateLocale},
+			status:      http.StatusOK,
+			response:    messageSchema(),
+		},
+		"GET /v1/admin/email-templates/:name/versions": {
+			summary:  "List the saved versions of an email template",
+			access:   accessAdmin,
+			params:   []parameter{emailTemplateName, emailTemplateLocale},
+			status:   http.StatusOK,
+			response: object(requiredField("versions", arrayOf(sr.model(data.EmailTemplate{})))),
+		},
+		"POST /v1/admin/email-templates/:name/versions": {
+			summary:     "Save a new version of an email template",
+			description: "The source must define the subject, plainBody and htmlBody templates and render with sample data. The new version is used for every email sent from then on.",
+			access:      accessAdmin,
+			params:      []parameter{emailTemplateName},
+			body: object(
+				field("locale", oneOf("en", "fr").with("default", "en")),
+				requiredField("source", str()),
+			),
+			status:   http.StatusCreated,
+			response: object(requiredField("email_template", sr.model(data.EmailTemplate{}))),
+			errors:   []int{http.StatusConflict},
+		},
+		"POST /v1/admin/email-templates/:name/versions/:version/activate": {
+			summary:  "Use an earlier version of an email template",
+			access:   accessAdmin,
+			params:   []parameter{emailTemplateName, pathParam("version", integer(), ""), emailTemplateLocale},
+			status:   http.StatusOK,
+			response: object(requiredField("email_template", sr.model(data.EmailTemplate{}))),
+		},
+		"POST /v1/admin/email-templates/:name/preview": {
+			summary:     "Preview an email template",
+			description: "Renders the template in use with sample data, or a saved version or unsaved source if one is given.",
+			access:      accessAdmin,
+			params:      []parameter{emailTemplateName},
+			body: object(
+				field("locale", oneOf("en", "fr").with("default", "en")),
+				field("version", integer()),
+				field("source", str()),
+				field("data", object().with("additionalProperties", true).with("description", "Replaces individual sample values.")),
+			),
+			status: http.StatusOK,
+			response: object(requiredField("preview", object(
+				requiredField("subject", str()),
+				requiredField("plain_body", str()),
+				requiredField("html_body", str()),
+			))),
+		},
+	}
+}
+
+// errorResponses describes the error envelopes written by the helpers in errors.go
+var errorResponses = map[int]string{
+	http.StatusBadRequest:          "The request body or a parameter couldn't be parsed.",
+	http.StatusUnauthorized:        "The credentials, token or API key are missing or invalid.",
+	http.StatusForbidden:           "The account isn't allowed to do this, for example because it isn't activated or lacks a permission.",
+	http.StatusNotFound:            "The requested resource could not be found.",
+	http.StatusConflict:            "The request conflicts with the current state of the resource, or it was changed by another request.",
+	http.StatusUnprocessableEntity: "The request failed validation. error maps each invalid field to a message.",
+	http.StatusTooManyRequests:     "A rate limit was exceeded. Retry-After says how many seconds to wait.",
+	http.StatusInternalServerError: "The server encountered an error and was unable to process the request.",
+	http.StatusServiceUnavailable:  "The service isn't ready to handle requests.",
+}
+
+// document builds the OpenAPI operation object for the route
+func (op operation) document(method, routePath string, sr *schemaRegistry) (schema, error) {
+	doc := schema{
+		"summary": op.summary,
+		"tags":    []string{routeTag(routePath)},
+	}
+	if op.description != "" {
+		doc["description"] = op.description
+	}
+
+	// path parameters not described by the operation are numeric ids or codes
+	var params []schema
+	errorSet := map[int]bool{http.StatusTooManyRequests: true, http.StatusInternalServerError: true}
+	for _, segment := range strings.Split(routePath, "/") {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := segment[1:]
+		p := pathParam(name, str(), "")
+		if strings.HasSuffix(name, "id") {
+			p = pathParam(name, integer(), "")
+		}
+		for _, documented := range op.params {
+			if documented.in == "path" && documented.name == name {
+				p = documented
+			}
+		}
+		params = append(params, p.document())
+		errorSet[http.StatusNotFound] = true
+	}
+	for _, p := range op.params {
+		if p.in == "query" {
+			params = append(params, p.document())
+			errorSet[http.StatusUnprocessableEntity] = true
+		}
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.body != nil {
+		bodyType := op.bodyType
+		if bodyType == "" {
+			bodyType = "application/json"
+		}
+		doc["requestBody"] = schema{
+			"required": true,
+			"content":  schema{bodyType: schema{"schema": op.body}},
+		}
+		errorSet[http.StatusBadRequest] = true
+		errorSet[http.StatusUnprocessableEntity] = true
+	}
+
+	switch op.access {
+	case accessAuthenticated, accessActivated, accessAdmin:
+		errorSet[http.StatusUnauthorized] = true
+		errorSet[http.StatusForbidden] = true
+	case accessTutor:
+		errorSet[http.StatusUnauthorized] = true
+		errorSet[http.StatusForbidden] = true
+		errorSet[http.StatusNotFound] = true
+	}
+	// API keys are only accepted where a permission is checked
+	switch op.access {
+	case accessPublic:
+	case accessAdmin:
+		doc["security"] = []schema{{"bearerAuth": []string{}}, {"apiKey": []string{}}}
+	default:
+		doc["security"] = []schema{{"bearerAuth": []string{}}}
+	}
+
+	contentType := op.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	responses := schema{
+		strconv.Itoa(op.status): schema{
+			"description": http.StatusText(op.status),
+			"content":     schema{contentType: schema{"schema": op.response}},
+		},
+	}
+
+	for _, status := range op.errors {
+		errorSet[status] = true
+	}
+	var err error
+	for status := range errorSet {
+		if _, found := errorResponses[status]; !found {
+			err = fmt.Errorf("%s %s has no error response for status %d", method, routePath, status)
+			continue
+		}
+		responses[strconv.Itoa(status)] = schema{"$ref": "#/components/responses/" + strconv.Itoa(status)}
+	}
+	doc["responses"] = responses
+
+	return doc, err
+}
+
+func (p parameter) document() schema {
+	doc := schema{
+		"name":     p.name,
+		"in":       p.in,
+		"required": p.required,
+		"schema":   p.schema,
+	}
+	if p.description != "" {
+		doc["description"] = p.description
+	}
+	return doc
+}
+
+// routeTag groups the routes by the first part of their path, with the admin routes
+// kept together
+func routeTag(routePath string) string {
+	parts := strings.Split(strings.TrimPrefix(routePath, "/v1/"), "/")
+	switch {
+	case parts[0] == "openapi.json" || strings.HasPrefix(parts[0], "health"):
+		return "operations"
+	case parts[0] == "photo-upload" || parts[0] == "users-by-role":
+		return "users"
+	default:
+		return parts[0]
+	}
+}
+
+// openAPIPath converts an httprouter path pattern to an OpenAPI one, so /v1/tutors/:id
+// becomes /v1/tutors/{id}
+func openAPIPath(routePath string) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// newOpenAPIDocument builds the OpenAPI document from the registered routes. It returns an
+// error if a route isn't documented in apiOperations, or a documented route isn't
+// registered, along with the document for the rest. openapi_test.go fails on the error,
+// so the document can't silently fall behind the route table.
+func newOpenAPIDocument(routes []route) (envelope, error) {
+	sr := newSchemaRegistry()
+	operations := apiOperations(sr)
+
+	var problems []string
+	documented := make(map[string]bool, len(routes))
+	paths := schema{}
+
+	for _, rt := range routes {
+		key := rt.method + " " + rt.path
+		op, found := operations[key]
+		if !found {
+			problems = append(problems, key+" is not documented")
+			continue
+		}
+		documented[key] = true
+
+		p := openAPIPath(rt.path)
+		item, _ := paths[p].(schema)
+		if item == nil {
+			item = schema{}
+			paths[p] = item
+		}
+		doc, err := op.document(rt.method, rt.path, sr)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		item[strings.ToLower(rt.method)] = doc
+	}
+
+	for key := range operations {
+		if !documented[key] {
+			problems = append(problems, key+" is documented but not registered")
+		}
+	}
+
+	responses := schema{}
+	for status, description := range errorResponses {
+		errorSchema := schema{"$ref": "#/components/schemas/Error"}
+		if status == http.StatusUnprocessableEntity {
+			errorSchema = schema{"$ref": "#/components/schemas/ValidationError"}
+		}
+
+		response := schema{
+			"description": description,
+			"content":     schema{"application/json": schema{"schema": errorSchema}},
+		}
+		if status == http.StatusTooManyRequests {
+			response["headers"] = schema{
+				"Retry-After": schema{"description": "Seconds to wait before trying again.", "schema": integer()},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+
+	sr.components["Error"] = object(requiredField("error", str()))
+	sr.components["ValidationError"] = object(requiredField("error", schema{
+		"type":                 "object",
+		"additionalProperties": str(),
+	}))
+
+	document := envelope{
+		"openapi": "3.0.3",
+		"info": envelope{
+			"title":       "IvyWhiz API",
+			"version":     version,
+			"description": "Every response is a JSON object with the data under a named key, such as {\"user\": {...}}. Errors are returned as {\"error\": \"message\"}, or {\"error\": {\"field\": \"message\"}} when validation fails.",
+		},
+		"paths": paths,
+		"components": envelope{
+			"schemas":   sr.components,
+			"responses": responses,
+			"securitySchemes": envelope{
+				"bearerAuth": envelope{"type": "http", "scheme": "bearer"},
+				"apiKey":     envelope{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "Only accepted on routes that check a permission, and limited to the key's scopes."},
+			},
+		},
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return document, errors.New("openapi: " + strings.Join(problems, ", "))
+	}
+
+	return document, nil
+}
+
+// serve the OpenAPI document describing every route
+func (app *application) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, app.openAPI, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/araromirichard/internal/jsonlog"
+)
+
+// TestEveryRouteIsDocumented fails when a route is registered without an entry in
+// apiOperations, or an entry is left behind after its route is removed
+func TestEveryRouteIsDocumented(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+	app.routes()
+
+	paths, ok := app.openAPI["paths"].(schema)
+	if !ok {
+		t.Fatal("OpenAPI document has no paths")
+	}
+
+	operations := apiOperations(newSchemaRegistry())
+	registered := map[string]bool{}
+
+	for _, rt := range *app.router().registered {
+		key := rt.method + " " + rt.path
+		registered[key] = true
+
+		if _, found := operations[key]; !found {
+			t.Errorf("%s is not documented in apiOperations", key)
+			continue
+		}
+
+		item, _ := paths[openAPIPath(rt.path)].(schema)
+		if _, found := item[strings.ToLower(rt.method)]; !found {
+			t.Errorf("%s is missing from the OpenAPI document", key)
+		}
+	}
+
+	for key := range operations {
+		if !registered[key] {
+			t.Errorf("%s is documented but not registered", key)
+		}
+	}
+}
+
+func TestOpenAPIDocumentBuilds(t *testing.T) {
+	app := &application{}
+
+	_, err := newOpenAPIDocument(*app.router().registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
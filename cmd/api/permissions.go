@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// list every permission code that can be granted
+func (app *application) ListPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the users who hold a permission, directly or through a role
+func (app *application) ListPermissionHoldersHandler(w http.ResponseWriter, r *http.Request) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !known.Include(code) {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	holders, err := app.models.Permissions.GetHolders(code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permission": code, "users": holders}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// show a user's effective permissions along with the direct grants and roles they come from
+func (app *application) GetUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user_id":     user.ID,
+		"permissions": effective,
+		"direct":      direct,
+		"roles":       roles,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grant permissions to a user directly
+func (app *application) GrantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePermissionCodes(v, input.Permissions, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	after, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+	app.recordAudit(r, "permission.grant", "user", strconv.FormatInt(user.ID, 10), envelope{"permissions": before}, envelope{"permissions": after})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "direct": after}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke a permission granted to a user directly
+func (app *application) RevokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	// stop admins from locking themselves out
+	v := validator.New()
+	v.Check(!(user.ID == app.contextGetUser(r).ID && code == "admin:access"), "permission", "validation.revoke_own_admin")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	before, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	after, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+	app.recordAudit(r, "permission.revoke", "user", strconv.FormatInt(user.ID, 10), envelope{"permissions": before}, envelope{"permissions": after})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.ID, "direct": after}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assign a role to a user
+func (app *application) AssignUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		RoleID int64 `json:"role_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.RoleID > 0, "role_id", "validation.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	role, err := app.models.Roles.Get(input.RoleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("role_id", "validation.role_not_found")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, role.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+	app.recordAudit(r, "role.assign", "user", strconv.FormatInt(user.ID, 10), nil, envelope{"role_id": role.ID, "role": role.Name})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role assigned successfully", "role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke a role from a user
+func (app *application) RemoveUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.getUserForAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	roleID, err := app.getRequestIDParam(r, "role_id")
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	role, err := app.models.Roles.Get(roleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// stop admins from locking themselves out
+	if user.ID == app.contextGetUser(r).ID {
+		locked, err := app.losesAdminAccess(user.ID, role)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v := validator.New()
+		v.Check(!locked, "role", "validation.remove_own_admin")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+	}
+
+	err = app.models.Roles.RemoveForUser(user.ID, role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.invalidatePermissions(user.ID)
+	app.recordAudit(r, "role.unassign", "user", strconv.FormatInt(user.ID, 10), envelope{"role_id": role.ID, "role": role.Name}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role removed successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// losesAdminAccess reports whether the user would be left without admin:access if the
+// role no longer granted them anything. Holding the permission directly or through
+// another role keeps it.
+func (app *application) losesAdminAccess(userID int64, role *data.Role) (bool, error) {
+	if !role.Permissions.Include("admin:access") {
+		return false, nil
+	}
+
+	remaining, err := app.models.Permissions.GetAllForUserWithoutRole(userID, role.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return !remaining.Include("admin:access"), nil
+}
+
+// getUserForAdmin loads the user named by the :id route parameter, writing the error
+// response itself when the user can't be found
+func (app *application) getUserForAdmin(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
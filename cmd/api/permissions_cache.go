@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/lib/pq"
+)
+
+// the Postgres channel the permission triggers notify on
+const permissionsChangedChannel = "permissions_changed"
+
+// permissionCache holds each user's permissions for a short time so that protected
+// routes don't have to query them on every request. Entries are dropped when the
+// database reports a change, and expire after the TTL as a backstop.
+type permissionCache struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[int64]permissionCacheEntry
+}
+
+type permissionCacheEntry struct {
+	permissions data.Permissions
+	expiry      time.Time
+}
+
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+func (c *permissionCache) get(userID int64) (data.Permissions, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || time.Now().After(entry.expiry) {
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+func (c *permissionCache) set(userID int64, permissions data.Permissions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[userID] = permissionCacheEntry{permissions: permissions, expiry: time.Now().Add(c.ttl)}
+}
+
+func (c *permissionCache) invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
+
+func (c *permissionCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]permissionCacheEntry)
+}
+
+// removeExpired drops the entries whose TTL has passed, so users who stop making
+// requests don't stay in memory
+func (c *permissionCache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for userID, entry := range c.entries {
+		if now.After(entry.expiry) {
+			delete(c.entries, userID)
+		}
+	}
+}
+
+// getPermissions returns the user's permissions, from the cache when it holds a fresh entry
+func (app *application) getPermissions(userID int64) (data.Permissions, error) {
+	if app.permissions == nil {
+		return app.models.Permissions.GetAllForUser(userID)
+	}
+
+	if permissions, ok := app.permissions.get(userID); ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.permissions.set(userID, permissions)
+	return permissions, nil
+}
+
+// invalidatePermissions drops the cached permissions of the given user straight away,
+// rather than waiting for the database notification to come back. A userID of 0 drops
+// every user's permissions.
+func (app *application) invalidatePermissions(userID int64) {
+	if app.permissions == nil {
+		return
+	}
+
+	if userID == 0 {
+		app.permissions.invalidateAll()
+		return
+	}
+	app.permissions.invalidate(userID)
+}
+
+// listenForPermissionChanges invalidates cached permissions and MFA policies when another
+// instance, or this one, changes them in the database. While the listener is disconnected
+// we may miss notifications, so both caches are cleared on every reconnect.
+func (app *application) listenForPermissionChanges() {
+	reportProblem := func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"listener": permissionsChangedChannel})
+		}
+	}
+
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, reportProblem)
+	for _, channel := range []string{permissionsChangedChannel, mfaPoliciesChangedChannel} {
+		err := listener.Listen(channel)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"listener": channel})
+		}
+	}
+
+	cleanup := time.NewTicker(time.Minute)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			// a nil notification means the connection was re-established
+			if n == nil {
+				app.permissions.invalidateAll()
+				app.mfaPolicies.invalidate()
+				continue
+			}
+			if n.Channel == mfaPoliciesChangedChannel {
+				app.mfaPolicies.invalidate()
+				continue
+			}
+			if n.Extra == "*" {
+				app.permissions.invalidateAll()
+				continue
+			}
+
+			userID, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				app.permissions.invalidateAll()
+				continue
+			}
+			app.permissions.invalidate(userID)
+
+		case <-cleanup.C:
+			app.permissions.removeExpired()
+
+			// check the connection is still alive, since a dropped connection is
+			// otherwise only noticed on the next notification
+			go listener.Ping()
+		}
+	}
+}
+
+// requestPermissions memoizes the authenticated user's permissions for the lifetime of a
+// single request, so stacked permission checks only look them up once
+type requestPermissions struct {
+	loaded      bool
+	permissions data.Permissions
+}
+
+// contextGetPermissions returns the permissions of the user in the request context,
+// loading them on first use
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, error) {
+	holder, ok := r.Context().Value(permissionsContextKey).(*requestPermissions)
+	if !ok {
+		return app.getPermissions(app.contextGetUser(r).ID)
+	}
+
+	if !holder.loaded {
+		permissions, err := app.getPermissions(app.contextGetUser(r).ID)
+		if err != nil {
+			return nil, err
+		}
+		holder.permissions = permissions
+		holder.loaded = true
+	}
+
+	return holder.permissions, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/araromirichard/internal/data"
+)
+
+// tutorPolicy decides whether the user, holding the given permissions, may act on the
+// tutor profile owned by ownerID
+type tutorPolicy func(user *data.User, permissions data.Permissions, ownerID int64) bool
+
+// canViewTutor lets tutors and admins read any tutor profile
+func canViewTutor(user *data.User, permissions data.Permissions, ownerID int64) bool {
+	return permissions.Include("admin:access") || permissions.Include("tutor:access")
+}
+
+// canEditTutor lets a tutor change only their own profile, while admins can change any
+func canEditTutor(user *data.User, permissions data.Permissions, ownerID int64) bool {
+	if permissions.Include("admin:access") {
+		return true
+	}
+	return permissions.Include("tutor:access") && user.ID == ownerID
+}
+
+// requireTutorPolicy looks up the owner of the tutor named by the :id route parameter and
+// checks the policy against the authenticated user before calling the next handler. An
+// unknown tutor is a 404, and a failed policy is always a 403 permission denied response.
+func (app *application) requireTutorPolicy(policy tutorPolicy, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.getRequestParams(r)
+		if err != nil {
+			app.NotFoundResponse(w, r)
+			return
+		}
+
+		ownerID, err := app.models.Tutors.GetId(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.NotFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		permissions, err := app.contextGetPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !policy(app.contextGetUser(r), permissions, ownerID) {
+			app.permissionDeniedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
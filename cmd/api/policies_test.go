@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/araromirichard/internal/data"
+)
+
+func TestCanEditTutor(t *testing.T) {
+	tutorA := &data.User{ID: 1, Activated: true}
+	tutorB := &data.User{ID: 2, Activated: true}
+	admin := &data.User{ID: 3, Activated: true}
+
+	tests := []struct {
+		name        string
+		user        *data.User
+		permissions data.Permissions
+		ownerID     int64
+		want        bool
+	}{
+		{"owner", tutorA, data.Permissions{"tutor:access"}, tutorA.ID, true},
+		{"another tutor", tutorB, data.Permissions{"tutor:access"}, tutorA.ID, false},
+		{"owner without tutor access", tutorA, data.Permissions{}, tutorA.ID, false},
+		{"admin", admin, data.Permissions{"admin:access"}, tutorA.ID, true},
+		{"user without the admin permission", admin, data.Permissions{"student:access"}, tutorA.ID, false},
+		{"anonymous", data.AnonymousUser, nil, tutorA.ID, false},
+		{"anonymous on an unowned profile", data.AnonymousUser, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canEditTutor(tt.user, tt.permissions, tt.ownerID); got != tt.want {
+				t.Errorf("canEditTutor() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanViewTutor(t *testing.T) {
+	user := &data.User{ID: 1, Activated: true}
+
+	tests := []struct {
+		name        string
+		user        *data.User
+		permissions data.Permissions
+		want        bool
+	}{
+		{"another tutor", user, data.Permissions{"tutor:access"}, true},
+		{"admin", user, data.Permissions{"admin:access"}, true},
+		{"student", user, data.Permissions{"student:access"}, false},
+		{"anonymous", data.AnonymousUser, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canViewTutor(tt.user, tt.permissions, 2); got != tt.want {
+				t.Errorf("canViewTutor() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireTutorPolicyAnonymous(t *testing.T) {
+	app := &application{}
+
+	called := false
+	handler := app.requireTutorPolicy(canEditTutor, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	r := httptest.NewRequest(http.MethodPatch, "/v1/tutors/IVW-1", nil)
+	r = app.contextSetUser(r, data.AnonymousUser)
+	w := httptest.NewRecorder()
+
+	handler(w, r)
+
+	if called {
+		t.Error("handler was called for an anonymous user")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
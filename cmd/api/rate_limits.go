@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araromirichard/internal/ratelimit"
+	"github.com/tomasen/realip"
+)
+
+// how often buckets that have refilled are forgotten
+const rateLimitCleanupInterval = time.Minute
+
+// rateLimitPolicy is the limit that applies to a group of routes. Each client IP address
+// has its own bucket for each policy.
+type rateLimitPolicy struct {
+	name  string
+	limit ratelimit.Limit
+}
+
+// credentialRoutes are the routes that check a password, a one-time code or an emailed
+// token, keyed by method and path as they are registered in routes(). That includes the
+// signed-in routes that ask for the current password or a code again, so a stolen access
+// token can't be used to guess them any faster than a login. The rest of /v1/auth and
+// /v1/tokens, such as refreshing a token or listing sessions, is used in the normal
+// course of things and isn't limited any more strictly than other routes.
+var credentialRoutes = map[string]bool{
+	"POST /v1/auth/login":              true,
+	"POST /v1/auth/login/mfa":          true,
+	"POST /v1/auth/magic-link":         true,
+	"POST /v1/auth/forgot-password":    true,
+	"POST /v1/auth/reset-password":     true,
+	"POST /v1/tokens/authentication":   true,
+	"POST /v1/tokens/magic-link":       true,
+	"POST /v1/auth/mfa/totp/confirm":   true,
+	"DELETE /v1/auth/mfa/totp":         true,
+	"POST /v1/auth/mfa/recovery-codes": true,
+	"PUT /v1/users/activate":           true,
+	"PUT /v1/users/me/password":        true,
+	"PUT /v1/users/me/email":           true,
+	"PUT /v1/users/email/confirm":      true,
+	"PUT /v1/users/email/revert":       true,
+	"GET /v1/users/me/export/download": true,
+	"POST /v1/users/me/erasure":        true,
+}
+
+// rateLimitPolicy picks the policy for the request. Routes that check credentials are the
+// strictest, since they are the ones worth hammering, while reads are the most relaxed.
+func (app *application) rateLimitPolicy(r *http.Request) rateLimitPolicy {
+	cfg := app.config.limiter
+
+	switch {
+	case credentialRoutes[r.Method+" "+r.URL.Path]:
+		return rateLimitPolicy{name: "auth", limit: ratelimit.Limit{Rate: cfg.authRPS, Burst: cfg.authBurst}}
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return rateLimitPolicy{name: "read", limit: ratelimit.Limit{Rate: cfg.readRPS, Burst: cfg.readBurst}}
+	default:
+		return rateLimitPolicy{name: "write", limit: ratelimit.Limit{Rate: cfg.rps, Burst: cfg.burst}}
+	}
+}
+
+// rateLimit limits requests per client IP address, using the policy for the route. It
+// runs before authentication, so failed authentication attempts count too.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled {
+			policy := app.rateLimitPolicy(r)
+			key := "ip:" + policy.name + ":" + realip.FromRequest(r)
+			if !app.takeRateLimit(w, r, policy.name, key, policy.limit) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitUser limits the requests of each authenticated user across all routes, so a
+// single account can't use up the API however many addresses it spreads its requests over
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled {
+			if user := app.contextGetUser(r); !user.IsAnonymous() {
+				key := "user:" + strconv.FormatInt(user.ID, 10)
+				limit := ratelimit.Limit{Rate: app.config.limiter.userRPS, Burst: app.config.limiter.userBurst}
+				if !app.takeRateLimit(w, r, "user", key, limit) {
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeRateLimit takes a token from the bucket for the key and sets the RateLimit headers.
+// It sends the error response and returns false when the bucket is empty. The policy
+// names the limit in the metrics.
+func (app *application) takeRateLimit(w http.ResponseWriter, r *http.Request, policy, key string, limit ratelimit.Limit) bool {
+	result, err := app.limiter.Take(r.Context(), key, limit)
+	if err != nil {
+		// a broken store shouldn't take the whole API down with it, so the request
+		// is let through
+		app.logger.PrintError(err, map[string]string{"rate_limit_key": key})
+		return true
+	}
+
+	setRateLimitHeaders(w, result)
+
+	if !result.Allowed {
+		app.metrics.rateLimitRejections.Inc(policy)
+		app.rateLimitExceededResponse(w, r, result.RetryAfter)
+		return false
+	}
+
+	return true
+}
+
+// setRateLimitHeaders describes the bucket in the RateLimit headers. When more than one
+// limit applies to a request, the headers describe the one closest to running out.
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	if existing := w.Header().Get("RateLimit-Remaining"); existing != "" {
+		remaining, err := strconv.Atoi(existing)
+		if err == nil && remaining < result.Remaining {
+			return
+		}
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+}
+
+// runRateLimitCleanup periodically forgets buckets that have refilled
+func (app *application) runRateLimitCleanup() {
+	for {
+		app.background(func() {
+			err := app.limiter.Cleanup(context.Background())
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+		time.Sleep(rateLimitCleanupInterval)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitPolicy(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodPost, "/v1/auth/login", "auth"},
+		{http.MethodPost, "/v1/auth/login/mfa", "auth"},
+		{http.MethodPost, "/v1/auth/magic-link", "auth"},
+		{http.MethodPost, "/v1/auth/forgot-password", "auth"},
+		{http.MethodPost, "/v1/auth/reset-password", "auth"},
+		{http.MethodPost, "/v1/tokens/authentication", "auth"},
+		{http.MethodPost, "/v1/tokens/magic-link", "auth"},
+		{http.MethodPost, "/v1/auth/mfa/totp/confirm", "auth"},
+		{http.MethodDelete, "/v1/auth/mfa/totp", "auth"},
+		{http.MethodPost, "/v1/auth/mfa/recovery-codes", "auth"},
+		{http.MethodPut, "/v1/users/activate", "auth"},
+		{http.MethodPut, "/v1/users/me/password", "auth"},
+		{http.MethodPut, "/v1/users/me/email", "auth"},
+		{http.MethodPut, "/v1/users/email/confirm", "auth"},
+		{http.MethodPut, "/v1/users/email/revert", "auth"},
+		{http.MethodGet, "/v1/users/me/export/download", "auth"},
+		{http.MethodPost, "/v1/users/me/erasure", "auth"},
+		{http.MethodPost, "/v1/auth/mfa/totp", "write"},
+		{http.MethodPut, "/v1/users/me/locale", "write"},
+		{http.MethodDelete, "/v1/users/me/erasure", "write"},
+		{http.MethodPost, "/v1/tokens/refresh", "write"},
+		{http.MethodPost, "/v1/auth/logout", "write"},
+		{http.MethodGet, "/v1/auth/sessions", "read"},
+		{http.MethodGet, "/v1/auth/mfa", "read"},
+		{http.MethodGet, "/v1/auth/login", "read"},
+		{http.MethodPost, "/v1/tutors", "write"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if got := app.rateLimitPolicy(r).name; got != tt.want {
+				t.Errorf("policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCredentialRoutesAreRegistered catches a credential route that was renamed in
+// routes() but not here, which would quietly move it to a more relaxed policy
+func TestCredentialRoutesAreRegistered(t *testing.T) {
+	app := &application{}
+
+	registered := map[string]bool{}
+	for _, rt := range *app.router().registered {
+		registered[rt.method+" "+rt.path] = true
+	}
+
+	for route := range credentialRoutes {
+		if !registered[route] {
+			t.Errorf("%s is not a registered route", route)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/araromirichard/internal/jsonlog"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tomasen/realip"
+)
+
+// request IDs passed in by a proxy or client are kept if they look sensible, so a request
+// can be followed through every service it touches
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// when access log sampling is turned on, each second the first successful requests are
+// logged and then every tenth one
+const (
+	accessLogSampleFirst      = 100
+	accessLogSampleThereafter = 10
+)
+
+// router is an httprouter.Router that records the pattern of the route each request
+// matched, so the access log groups requests by route rather than by URL. It also keeps
+// a list of the registered routes, which the OpenAPI document is generated from.
+type router struct {
+	*httprouter.Router
+	registered *[]route
+}
+
+// route is a registered method and path pattern, such as GET /v1/tutors/:id
+type route struct {
+	method string
+	path   string
+}
+
+func newRouter() router {
+	return router{Router: httprouter.New(), registered: &[]route{}}
+}
+
+func (rt router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	*rt.registered = append(*rt.registered, route{method: method, path: path})
+	rt.Router.HandlerFunc(method, path, func(w http.ResponseWriter, r *http.Request) {
+		if entry := contextGetAccessLog(r); entry != nil {
+			entry.route = path
+		}
+		handler(w, r)
+	})
+}
+
+// accessLog collects what the access log line needs to know from further down the
+// middleware chain, such as the route pattern and the authenticated user
+type accessLog struct {
+	route  string
+	userID int64
+}
+
+func contextGetAccessLog(r *http.Request) *accessLog {
+	entry, _ := r.Context().Value(accessLogContextKey).(*accessLog)
+	return entry
+}
+
+// responseRecorder records the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// requestID gives every request an ID, keeping the one in the X-Request-ID header if
+// there is one. The ID is sent back in the response so clients can quote it.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			id = newRequestID()
+		}
+
+		r.Header.Set("X-Request-ID", id)
+		w.Header().Set("X-Request-ID", id)
+
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand never fails on the platforms we run on
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequest writes one access log line for every request once it has been handled, and
+// records it in the request metrics. With -log-access-sample a busy instance only logs a
+// sample of its successful requests; every other response is always logged.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	logger := app.logger.With(jsonlog.String("log", "access"))
+	sampled := logger
+	if app.config.log.sampleAccess {
+		sampled = logger.Sampled(accessLogSampleFirst, accessLogSampleThereafter)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &accessLog{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		duration := time.Since(start)
+		app.metrics.observeRequest(entry.route, r.Method, rec.status, duration)
+
+		fields := []jsonlog.Field{
+			jsonlog.String("request_id", app.contextGetRequestID(r)),
+			jsonlog.String("method", r.Method),
+			jsonlog.String("route", entry.route),
+			jsonlog.Int("status", rec.status),
+			jsonlog.Int("bytes", rec.bytes),
+			jsonlog.Duration("duration", duration),
+			jsonlog.String("ip", realip.FromRequest(r)),
+		}
+		if entry.userID != 0 {
+			fields = append(fields, jsonlog.Int64("user_id", entry.userID))
+		}
+
+		switch {
+		case rec.status >= http.StatusInternalServerError:
+			logger.Warn("request", fields...)
+		case rec.status >= http.StatusMultipleChoices:
+			logger.Info("request", fields...)
+		default:
+			sampled.Info("request", fields...)
+		}
+	})
+}
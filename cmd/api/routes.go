@@ -2,14 +2,27 @@ package main
 
 import (
 	"net/http"
-
-	"github.com/julienschmidt/httprouter"
 )
 
 // Define routes for the API server.
 func (app *application) routes() http.Handler {
+	r := app.router()
+
+	// every route should be documented, which openapi_test.go checks. A gap is logged
+	// rather than stopping the server, and the route is left out of the document.
+	openAPI, err := newOpenAPIDocument(*r.registered)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+	app.openAPI = openAPI
+
+	return app.requestID(app.logRequest(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.rateLimitUser(r)))))))
+}
+
+// router registers the API's routes on a new router
+func (app *application) router() router {
 	// Initiallize a new router instance
-	r := httprouter.New()
+	r := newRouter()
 
 	//custom error router responses
 	r.NotFound = http.HandlerFunc(app.NotFoundResponse)
@@ -17,6 +30,11 @@ func (app *application) routes() http.Handler {
 
 	//api route to ping
 	r.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.HealthCheckHandler)
+	r.HandlerFunc(http.MethodGet, "/v1/health/live", app.LivenessHandler)
+	r.HandlerFunc(http.MethodGet, "/v1/health/ready", app.ReadinessHandler)
+
+	// OpenAPI document generated from the routes below, see openapi.go
+	r.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.OpenAPIHandler)
 
 	// Get all users By Role
 	r.HandlerFunc(http.MethodGet, "/v1/users-by-role", app.GetUserByRoleHandler)
@@ -24,9 +42,24 @@ func (app *application) routes() http.Handler {
 	// Authentications/ users
 	r.HandlerFunc(http.MethodPost, "/v1/auth/register", app.createUserHandler)
 	r.HandlerFunc(http.MethodPost, "/v1/auth/login", app.loginUserHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/auth/login/mfa", app.loginMFAHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/auth/magic-link", app.RequestMagicLinkHandler)
 	r.HandlerFunc(http.MethodGet, "/v1/auth/verify-email-token", app.resendActivationTokenHandler)
 	r.HandlerFunc(http.MethodPost, "/v1/auth/forgot-password", app.ForgotPasswordHandler)
 	r.HandlerFunc(http.MethodPost, "/v1/auth/reset-password", app.ResetPasswordHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/auth/logout", app.requireAuthenticatedUser(app.LogoutHandler))
+
+	//sessions
+	r.HandlerFunc(http.MethodGet, "/v1/auth/sessions", app.requireAuthenticatedUser(app.ListSessionsHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/auth/sessions", app.requireAuthenticatedUser(app.RevokeAllSessionsHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/auth/sessions/:id", app.requireAuthenticatedUser(app.RevokeSessionHandler))
+
+	//two-factor authentication
+	r.HandlerFunc(http.MethodGet, "/v1/auth/mfa", app.requireAuthenticatedUser(app.GetMFAStatusHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/auth/mfa/totp", app.requireAuthenticatedUser(app.StartTOTPEnrolmentHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/auth/mfa/totp/confirm", app.requireAuthenticatedUser(app.ConfirmTOTPEnrolmentHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/auth/mfa/totp", app.requireAuthenticatedUser(app.DisableTOTPHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/auth/mfa/recovery-codes", app.requireAuthenticatedUser(app.RegenerateRecoveryCodesHandler))
 
 	//get current logged in user
 	r.HandlerFunc(http.MethodGet, "/v1/auth/whoami", app.requireActivatedUser(app.WhoAmIHandler))
@@ -38,25 +71,42 @@ func (app *application) routes() http.Handler {
 	r.HandlerFunc(http.MethodPost, "/v1/users/photo", app.createUserPhotoHandler)
 	r.HandlerFunc(http.MethodPut, "/v1/users/photo/:id", app.updateUserPhotoHandler)
 
+	//password and email changes
+	r.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.ChangePasswordHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/users/me/email", app.requireActivatedUser(app.RequestEmailChangeHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/users/me/locale", app.requireActivatedUser(app.UpdateLocaleHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/users/email/confirm", app.ConfirmEmailChangeHandler)
+	r.HandlerFunc(http.MethodPut, "/v1/users/email/revert", app.RevertEmailChangeHandler)
+
+	//personal data export
+	r.HandlerFunc(http.MethodPost, "/v1/users/me/export", app.requireActivatedUser(app.RequestDataExportHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/users/me/export/download", app.DownloadDataExportHandler)
+
+	//account erasure
+	r.HandlerFunc(http.MethodPost, "/v1/users/me/erasure", app.requireActivatedUser(app.RequestAccountErasureHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me/erasure", app.requireActivatedUser(app.CancelAccountErasureHandler))
+
 	//tokens
 	r.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
 	r.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 
 	//tutors Specific Routes
 	r.HandlerFunc(http.MethodPost, "/v1/tutors", app.requireActivatedUser(app.CreateTutorHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id", app.requirePermission("tutor:access", app.GetTutorHandler))
-	r.HandlerFunc(http.MethodPatch, "/v1/tutors/:id", app.requirePermission("tutor:access", app.UpdateTutorHandler))
-	r.HandlerFunc(http.MethodDelete, "/v1/tutors/:id", app.requirePermission("tutor:access", app.DeleteTutorHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/education", app.requirePermission("tutor:access", app.CreateTutorEducationHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/education", app.requirePermission("tutor:access", app.ListTutorEducationHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/languages", app.requirePermission("tutor:access", app.CreateTutorLanguagesHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/languages", app.requirePermission("tutor:access", app.ListTutorLanguagesHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/schedules", app.requirePermission("tutor:access", app.CreateTutorScheduleHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/schedules", app.requirePermission("tutor:access", app.ListTutorScheduleHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/employments", app.requirePermission("tutor:access", app.CreateTutorEmploymentHistoryHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/employments", app.requirePermission("tutor:access", app.ListTutorEmploymentHistoryHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/skills", app.requirePermission("tutor:access", app.CreateTutorSkillHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/skills", app.requirePermission("tutor:access", app.ListTutorSkillsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id", app.requireTutorPolicy(canViewTutor, app.GetTutorHandler))
+	r.HandlerFunc(http.MethodPatch, "/v1/tutors/:id", app.requireTutorPolicy(canEditTutor, app.UpdateTutorHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/tutors/:id", app.requireTutorPolicy(canEditTutor, app.DeleteTutorHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/education", app.requireTutorPolicy(canEditTutor, app.CreateTutorEducationHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/education", app.requireTutorPolicy(canViewTutor, app.ListTutorEducationHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/languages", app.requireTutorPolicy(canEditTutor, app.CreateTutorLanguagesHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/languages", app.requireTutorPolicy(canViewTutor, app.ListTutorLanguagesHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/schedules", app.requireTutorPolicy(canEditTutor, app.CreateTutorScheduleHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/schedules", app.requireTutorPolicy(canViewTutor, app.ListTutorScheduleHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/employments", app.requireTutorPolicy(canEditTutor, app.CreateTutorEmploymentHistoryHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/employments", app.requireTutorPolicy(canViewTutor, app.ListTutorEmploymentHistoryHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tutors/:id/skills", app.requireTutorPolicy(canEditTutor, app.CreateTutorSkillHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/skills", app.requireTutorPolicy(canViewTutor, app.ListTutorSkillsHandler))
 	// r.HandlerFunc(http.MethodGet, "/v1/tutors/:id/ratings", app.ListTutorRatingsHandler)
 
 	//Students Specific Routes
@@ -67,7 +117,45 @@ func (app *application) routes() http.Handler {
 
 	//Admin Specific Routes
 	r.HandlerFunc(http.MethodPatch, "/v1/admin/tutors/:id", app.requirePermission("admin:access", app.UpdateTutorVerificationHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("admin:access", app.ListAuditEventsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/stats", app.requirePermission("admin:access", app.GetAdminStatsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("admin:access", app.GetUserByIdHandler))
+	r.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("admin:access", app.UpdateUserByIdHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("admin:access", app.DeleteUserByIdHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/suspend", app.requirePermission("admin:access", app.SuspendUserHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/unsuspend", app.requirePermission("admin:access", app.UnsuspendUserHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/unlock", app.requirePermission("admin:access", app.UnlockUserHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/mfa", app.requirePermission("admin:access", app.ResetUserMFAHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("admin:access", app.GetUserPermissionsHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("admin:access", app.GrantUserPermissionsHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("admin:access", app.RevokeUserPermissionHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("admin:access", app.AssignUserRoleHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role_id", app.requirePermission("admin:access", app.RemoveUserRoleHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("admin:access", app.ListRolesHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("admin:access", app.CreateRoleHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id", app.requirePermission("admin:access", app.GetRoleHandler))
+	r.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("admin:access", app.UpdateRoleHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("admin:access", app.DeleteRoleHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/roles/:id/users", app.requirePermission("admin:access", app.ListRoleMembersHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/mfa/policies", app.requirePermission("admin:access", app.ListMFAPoliciesHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/mfa/policies/:role", app.requirePermission("admin:access", app.UpdateMFAPolicyHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/service-accounts", app.requirePermission("admin:access", app.ListServiceAccountsHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/service-accounts", app.requirePermission("admin:access", app.CreateServiceAccountHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/service-accounts/:id", app.requirePermission("admin:access", app.DeleteServiceAccountHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/service-accounts/:id/keys", app.requirePermission("admin:access", app.ListAPIKeysHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/service-accounts/:id/keys", app.requirePermission("admin:access", app.CreateAPIKeyHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/service-accounts/:id/keys/:key_id/rotate", app.requirePermission("admin:access", app.RotateAPIKeyHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/service-accounts/:id/keys/:key_id", app.requirePermission("admin:access", app.RevokeAPIKeyHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/log-level", app.requirePermission("admin:access", app.GetLogLevelHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/log-level", app.requirePermission("admin:access", app.UpdateLogLevelHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("admin:access", app.ListPermissionsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/permissions/:code/users", app.requirePermission("admin:access", app.ListPermissionHoldersHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/email-templates", app.requirePermission("admin:access", app.ListEmailTemplatesHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/email-templates/:name", app.requirePermission("admin:access", app.ResetEmailTemplateHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/email-templates/:name/versions", app.requirePermission("admin:access", app.ListEmailTemplateVersionsHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/email-templates/:name/versions", app.requirePermission("admin:access", app.CreateEmailTemplateVersionHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/email-templates/:name/versions/:version/activate", app.requirePermission("admin:access", app.ActivateEmailTemplateVersionHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/email-templates/:name/preview", app.requirePermission("admin:access", app.PreviewEmailTemplateHandler))
 
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(r))))
-
+	return r
 }
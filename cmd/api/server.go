@@ -21,6 +21,9 @@ func (app *application) serve() error {
 		IdleTimeout:  time.Minute,
 	}
 
+	// Prometheus scrapes the metrics on a port of their own, so they aren't public
+	metricsSrv := app.serveMetrics()
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -47,6 +50,15 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Fail readiness checks straight away, and keep serving requests for a while so
+		// the load balancer notices and stops sending new traffic before we stop
+		// accepting it.
+		app.shuttingDown.Store(true)
+		app.logger.PrintInfo("draining traffic", map[string]string{
+			"period": app.config.shutdown.drain.String(),
+		})
+		time.Sleep(app.config.shutdown.drain)
+
 		// Create a context with a 5-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -55,6 +67,12 @@ func (app *application) serve() error {
 		// error (which may happen because of a problem closing the listeners, or
 		// because the shutdown didn't complete before the 5-second context deadline is
 		// hit). We relay this return value to the shutdownError channel.
+		if metricsSrv != nil {
+			if err := metricsSrv.Shutdown(ctx); err != nil {
+				app.logger.PrintError(err, map[string]string{"addr": metricsSrv.Addr})
+			}
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 
@@ -98,3 +116,35 @@ func (app *application) serve() error {
 	})
 	return nil
 }
+
+// serveMetrics starts serving /metrics and the detailed readiness check on the metrics
+// port, away from the public API, and returns the server so it can be shut down. It
+// returns nil when the port is 0.
+func (app *application) serveMetrics() *http.Server {
+	if app.config.metrics.port == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", app.MetricsHandler)
+	mux.HandleFunc("/health/ready", app.InternalReadinessHandler)
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.metrics.port),
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  time.Minute,
+	}
+
+	go func() {
+		app.logger.PrintInfo("Starting metrics server", map[string]string{"addr": srv.Addr})
+
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.PrintError(err, map[string]string{"addr": srv.Addr})
+		}
+	}()
+
+	return srv
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/tomasen/realip"
+)
+
+// startSession records a new signed in device for the user and issues the short-lived
+// access token and the refresh token for it. mfaVerified says whether the user signed
+// in with a second factor.
+func (app *application) startSession(r *http.Request, user *data.User, mfaVerified bool) (*data.Token, *data.Token, error) {
+	session := &data.Session{
+		UserID:      user.ID,
+		IP:          realip.FromRequest(r),
+		UserAgent:   r.UserAgent(),
+		MFAVerified: mfaVerified,
+	}
+
+	err := app.models.Sessions.Insert(session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err := app.models.Tokens.NewForSession(user.ID, session.ID, app.config.tokens.accessTTL, data.ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := app.models.Tokens.NewForSession(user.ID, session.ID, app.config.tokens.refreshTTL, data.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// revokeAllSessions logs the user out on every device
+func (app *application) revokeAllSessions(userID int64) error {
+	return app.models.Sessions.DeleteAllForUser(userID)
+}
+
+// log out the current session
+func (app *application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	session := app.contextGetSession(r)
+	if session != nil {
+		err := app.models.Sessions.Delete(session.ID, user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		// tokens issued before sessions existed are revoked on their own
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		err := app.models.Tokens.DeleteForPlaintext(data.ScopeAuthentication, token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.recordAudit(r, "session.logout", "user", strconv.FormatInt(user.ID, 10), session, nil)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "logged out successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the devices the user is signed in on
+func (app *application) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// flag the session making this request so clients can tell it apart
+	type sessionResponse struct {
+		*data.Session
+		Current bool `json:"current"`
+	}
+
+	current := app.contextGetSession(r)
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{
+			Session: session,
+			Current: current != nil && current.ID == session.ID,
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke one of the user's sessions, signing that device out
+func (app *application) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Sessions.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "session.revoke", "user", strconv.FormatInt(user.ID, 10), envelope{"session_id": id}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session revoked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// log the user out everywhere, including the current session
+func (app *application) RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "session.revoke_all", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/validator"
+)
+
+// admin dashboard statistics over a date range.
+//
+// TODO: bookings and revenue per period, and the most searched skills, are still to be
+// added. There is no bookings or payments table to aggregate yet, and tutor searches
+// aren't recorded, so they need that groundwork first.
+func (app *application) GetAdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Default to the last 30 days, grouped by day
+	now := time.Now().UTC()
+	sr := data.StatsRange{
+		From:        now.Truncate(24*time.Hour).AddDate(0, 0, -30),
+		To:          now,
+		Granularity: app.readString(qs, "granularity", "day"),
+	}
+	if from := app.readDate(qs, "from", false, v); from != nil {
+		sr.From = *from
+	}
+	if to := app.readDate(qs, "to", true, v); to != nil {
+		sr.To = *to
+	}
+
+	if data.ValidateStatsRange(v, sr); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	signups, err := app.models.Stats.Signups(sr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	activation, err := app.models.Stats.Activation(sr)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tutors, err := app.models.Stats.TutorsByVerification()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	skills, err := app.models.Stats.TopOfferedSkills(10)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats := envelope{
+		"range":                  sr,
+		"signups":                signups,
+		"activation":             activation,
+		"tutors_by_verification": tutors,
+		"top_offered_skills":     skills,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
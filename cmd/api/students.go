@@ -54,7 +54,7 @@ package main
 // 	// Validate the input
 // 	v := validator.New()
 // 	if data.ValidateStudent(v, student); !v.Valid() {
-// 		app.failedValidationResponse(w, r, v.Errors)
+// 		app.failedValidationResponse(w, r, v)
 // 		return
 // 	}
 
@@ -170,7 +170,7 @@ package main
 // 	//validate the student data
 // 	v := validator.New()
 // 	if data.ValidateStudent(v, student); !v.Valid() {
-// 		app.failedValidationResponse(w, r, v.Errors)
+// 		app.failedValidationResponse(w, r, v)
 // 		return
 // 	}
 
@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
 	"github.com/araromirichard/internal/validator"
 )
 
@@ -29,7 +31,14 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	data.ValidatePasswordPlaintext(v, input.Password)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Turn the attempt away if there have been too many failed logins for this email
+	// or from this IP address recently.
+	if !app.loginAllowed(w, r, input.Email) {
+		return
 	}
 
 	// Lookup the user record based on the email address. If no matching user was
@@ -39,6 +48,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordLoginFailure(r, input.Email, nil)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -54,24 +64,129 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
 	if !match {
+		app.recordLoginFailure(r, input.Email, user)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+	// Users with two-factor authentication enabled have to complete a second step
+	// before they get their tokens. The login only counts as successful once they have.
+	challenge, err := app.newMFAChallenge(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if challenge != nil {
+		app.mfaChallengeResponse(w, r, challenge)
+		return
+	}
+
+	app.recordLoginSuccess(r, input.Email)
+
+	// Otherwise, if the password is correct, we start a new session and generate a
+	// short-lived authentication token for it, along with the refresh token used to
+	// renew it.
+	token, refreshToken, err := app.startSession(r, user, false)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new authentication
+// token. Refresh tokens are single use: each call returns a new one, and presenting a
+// refresh token that was already exchanged revokes the whole session, since it means
+// someone else has a copy of it.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	refresh, err := app.models.Tokens.GetRefresh(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if refresh.Used {
+		app.revokeReusedRefreshToken(w, r, refresh)
+		return
+	}
+
+	user, err := app.models.Users.GetUser(refresh.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	token, refreshToken, err := app.models.Tokens.Rotate(refresh, app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.revokeReusedRefreshToken(w, r, refresh)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// revokeReusedRefreshToken ends the session a replayed refresh token belongs to, so
+// neither the legitimate client nor whoever copied the token can keep using it
+func (app *application) revokeReusedRefreshToken(w http.ResponseWriter, r *http.Request, refresh *data.Token) {
+	err := app.models.Sessions.Delete(refresh.SessionID, refresh.UserID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "session.refresh_reuse", "user", strconv.FormatInt(refresh.UserID, 10), envelope{"session_id": refresh.SessionID}, nil)
 
+	app.invalidRefreshTokenResponse(w, r)
 }
 
 func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -86,7 +201,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 	v := validator.New()
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// Try to retrieve the corresponding user record for the email address. If it can't
@@ -95,8 +210,8 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("email", "validation.email_not_found")
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -105,8 +220,8 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 
 	// Return an error if the user has already been activated.
 	if user.Activated {
-		v.AddError("email", "user has already been activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		v.AddError("email", "validation.already_activated")
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// Otherwise, create a new activation token.
@@ -117,16 +232,19 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 
 	// Email the user with their additional activation token.
-	app.background(func() {
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
+			"firstName":       user.FirstName,
+			"logoURL":         "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
 		}
 		// Since email addresses MAY be case sensitive, notice that we are sending this
 		// email using the address stored in our database for the user --- not to the
 		// input.Email address provided by the client in this request.
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		err = app.mailer.Send(user.Email, locale, mailer.VerifyEmailTemplate, data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 		}
 	})
 	// Send a 202 Accepted response and confirmation message to the client.
@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
 	"github.com/araromirichard/internal/validator"
 	"github.com/lib/pq"
 )
@@ -82,6 +84,9 @@ func (app *application) CreateTutorHandler(w http.ResponseWriter, r *http.Reques
 		app.logger.PrintError(err, nil)
 		return
 	}
+	app.recordAudit(r, "permission.grant", "user", strconv.FormatInt(user.ID, 10), nil,
+		map[string]interface{}{"codes": []string{"tutor:access"}},
+	)
 
 	//send a response
 	message := "Tutor profile created successfully, please wait for verification."
@@ -143,22 +148,6 @@ func (app *application) UpdateTutorHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	// Fetch the existing tutor record from the database
 	tutor, err := app.models.Tutors.GetByID(id)
 	if err != nil {
@@ -188,7 +177,7 @@ func (app *application) UpdateTutorHandler(w http.ResponseWriter, r *http.Reques
 	// Validate the input
 	v := validator.New()
 	if data.ValidateTutor(v, tutor); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -216,16 +205,6 @@ func (app *application) DeleteTutorHandler(w http.ResponseWriter, r *http.Reques
 		app.NotFoundResponse(w, r)
 		return
 	}
-	//get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
 
 	// delete the tutor from the database
 	err = app.models.Tutors.DeleteTutor(id)
@@ -249,46 +228,39 @@ func (app *application) DeleteTutorHandler(w http.ResponseWriter, r *http.Reques
 
 // create tutor languages
 func (app *application) CreateTutorLanguagesHandler(w http.ResponseWriter, r *http.Request) {
+	//get the ivw_id from the request
+	id, err := app.getRequestParams(r)
+	if err != nil || id == "" {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
 	// parse the create tutor data from the request body
 	var input struct {
 		IvwID     string         `json:"ivw_id"`
 		Languages pq.StringArray `json:"languages"`
 	}
 
-	err := app.readJSON(w, r, &input)
+	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	// validate the input
 	v := validator.New()
 
-	data.ValidateTutorIvwID(v, input.IvwID)
+	// the tutor comes from the URL, an ivw_id in the body is only accepted if it matches
+	v.Check(input.IvwID == "" || input.IvwID == id, "ivw_id", "validation.match_tutor")
+	data.ValidateTutorLanguages(v, input.Languages)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// insert the tutor data into the database
-	_, err = app.models.Tutors.CreateTutorLanguages(input.IvwID, input.Languages)
+	_, err = app.models.Tutors.CreateTutorLanguages(id, input.Languages)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -331,6 +303,13 @@ func (app *application) ListTutorLanguagesHandler(w http.ResponseWriter, r *http
 
 // create a tutor education background
 func (app *application) CreateTutorEducationHandler(w http.ResponseWriter, r *http.Request) {
+	//get the ivw_id from the request
+	id, err := app.getRequestParams(r)
+	if err != nil || id == "" {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
 	//parse the create tutor data from the request body
 	var Input struct {
 		IvwID     string `json:"ivw_id"`
@@ -340,27 +319,12 @@ func (app *application) CreateTutorEducationHandler(w http.ResponseWriter, r *ht
 		EndYear   int32  `json:"end_year"`
 	}
 
-	err := app.readJSON(w, r, &Input)
+	err = app.readJSON(w, r, &Input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	tutorEducation := &data.Education{
 		Institute: Input.Institute,
 		Course:    Input.Course,
@@ -376,10 +340,16 @@ func (app *application) CreateTutorEducationHandler(w http.ResponseWriter, r *ht
 		StartYear: Input.StartYear,
 		EndYear:   Input.EndYear,
 	})
-	data.ValidateTutorIvwID(v, Input.IvwID)
+	// the tutor comes from the URL, an ivw_id in the body is only accepted if it matches
+	v.Check(Input.IvwID == "" || Input.IvwID == id, "ivw_id", "validation.match_tutor")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 
 	//Insert the tutor data into the database
-	err = app.models.Tutors.CreateTutorEducation(Input.IvwID, tutorEducation.Course, tutorEducation.StartYear, tutorEducation.EndYear, tutorEducation.Institute)
+	err = app.models.Tutors.CreateTutorEducation(id, tutorEducation.Course, tutorEducation.StartYear, tutorEducation.EndYear, tutorEducation.Institute)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -421,6 +391,13 @@ func (app *application) ListTutorEducationHandler(w http.ResponseWriter, r *http
 
 // create a tutor schedule
 func (app *application) CreateTutorScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	//get the ivw_id from the request
+	id, err := app.getRequestParams(r)
+	if err != nil || id == "" {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
 	//parse the create tutor data from the request body
 	var Input struct {
 		IvwID     string    `json:"ivw_id"`
@@ -429,27 +406,12 @@ func (app *application) CreateTutorScheduleHandler(w http.ResponseWriter, r *htt
 		EndTime   time.Time `json:"end_time"`
 	}
 
-	err := app.readJSON(w, r, &Input)
+	err = app.readJSON(w, r, &Input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	tutorSchedule := &data.Schedule{
 		Day:       Input.Day,
 		StartTime: Input.StartTime,
@@ -463,10 +425,16 @@ func (app *application) CreateTutorScheduleHandler(w http.ResponseWriter, r *htt
 		StartTime: Input.StartTime,
 		EndTime:   Input.EndTime,
 	})
-	data.ValidateTutorIvwID(v, Input.IvwID)
+	// the tutor comes from the URL, an ivw_id in the body is only accepted if it matches
+	v.Check(Input.IvwID == "" || Input.IvwID == id, "ivw_id", "validation.match_tutor")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 
 	//Insert the tutor data into the database
-	err = app.models.Tutors.CreateTutorSchedule(Input.IvwID, tutorSchedule.Day, tutorSchedule.StartTime, tutorSchedule.EndTime)
+	err = app.models.Tutors.CreateTutorSchedule(id, tutorSchedule.Day, tutorSchedule.StartTime, tutorSchedule.EndTime)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -535,6 +503,13 @@ func (app *application) ListTutorRatingsHandler(w http.ResponseWriter, r *http.R
 
 // create a tutor employment History
 func (app *application) CreateTutorEmploymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	//get the ivw_id from the request
+	id, err := app.getRequestParams(r)
+	if err != nil || id == "" {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
 	//parse the create tutor data from the request body
 	var Input struct {
 		IvwID     string    `json:"ivw_id"`
@@ -544,27 +519,12 @@ func (app *application) CreateTutorEmploymentHistoryHandler(w http.ResponseWrite
 		EndDate   time.Time `json:"end_date"`
 	}
 
-	err := app.readJSON(w, r, &Input)
+	err = app.readJSON(w, r, &Input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	tutorEmploymentHistory := &data.EmploymentHistory{
 		Company:   Input.Company,
 		Position:  Input.Position,
@@ -579,10 +539,16 @@ func (app *application) CreateTutorEmploymentHistoryHandler(w http.ResponseWrite
 		StartDate: Input.StartDate,
 		EndDate:   Input.EndDate,
 	})
-	data.ValidateTutorIvwID(v, Input.IvwID)
+	// the tutor comes from the URL, an ivw_id in the body is only accepted if it matches
+	v.Check(Input.IvwID == "" || Input.IvwID == id, "ivw_id", "validation.match_tutor")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
 
 	//Insert the tutor data into the database
-	err = app.models.Tutors.CreateTutorEmploymentHistory(Input.IvwID, tutorEmploymentHistory.Company, tutorEmploymentHistory.Position, tutorEmploymentHistory.StartDate, tutorEmploymentHistory.EndDate)
+	err = app.models.Tutors.CreateTutorEmploymentHistory(id, tutorEmploymentHistory.Company, tutorEmploymentHistory.Position, tutorEmploymentHistory.StartDate, tutorEmploymentHistory.EndDate)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -621,45 +587,39 @@ func (app *application) ListTutorEmploymentHistoryHandler(w http.ResponseWriter,
 	}
 }
 func (app *application) CreateTutorSkillHandler(w http.ResponseWriter, r *http.Request) {
+	//get the ivw_id from the request
+	id, err := app.getRequestParams(r)
+	if err != nil || id == "" {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
 	//parse the create tutor data from the request body
 	var Input struct {
 		IvwID  string         `json:"ivw_id"`
 		Skills pq.StringArray `json:"skills"`
 	}
 
-	err := app.readJSON(w, r, &Input)
+	err = app.readJSON(w, r, &Input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//get the user from the context
-	user := app.contextGetUser(r)
-	if user == nil {
-		app.NotFoundResponse(w, r)
-		return
-	}
-	if user.IsAnonymous() {
-		app.authenticationRequiredResponse(w, r)
-		return
-	}
-	if user.Role != "tutor" {
-		app.permissionDeniedResponse(w, r)
-		return
-	}
-
 	//validate the input
 	v := validator.New()
 
-	data.ValidateTutorIvwID(v, Input.IvwID)
+	// the tutor comes from the URL, an ivw_id in the body is only accepted if it matches
+	v.Check(Input.IvwID == "" || Input.IvwID == id, "ivw_id", "validation.match_tutor")
+	data.ValidateTutorSkills(v, Input.Skills)
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	//Insert the tutor data into the database
-	_, err = app.models.Tutors.CreateTutorSkills(Input.IvwID, Input.Skills)
+	_, err = app.models.Tutors.CreateTutorSkills(id, Input.Skills)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -743,6 +703,12 @@ func (app *application) UpdateTutorVerificationHandler(w http.ResponseWriter, r
 		return
 	}
 
+	verified, err := app.models.Tutors.GetVerification(input.IvwID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Update the tutor data in the database
 	err = app.models.Tutors.VerifyTutor(input.IvwID)
 	if err != nil {
@@ -750,6 +716,11 @@ func (app *application) UpdateTutorVerificationHandler(w http.ResponseWriter, r
 		return
 	}
 
+	app.recordAudit(r, "tutor.verify", "tutor", input.IvwID,
+		map[string]interface{}{"verification": verified},
+		map[string]interface{}{"verification": true},
+	)
+
 	// Create the notification payload
 	notificationPayload := map[string]interface{}{
 		"title":   "Congratulations!",
@@ -764,14 +735,15 @@ func (app *application) UpdateTutorVerificationHandler(w http.ResponseWriter, r
 	}
 
 	// Send email asynchronously
-	app.background(func() {
+	locale := app.userLocale(r, tutor)
+	app.backgroundForRequest(r, func() {
 		data := map[string]interface{}{
 			"tutorName": tutor.FirstName + " " + tutor.LastName,
 			"logoURL":   "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
 		}
-		err := app.mailer.Send(tutor.Email, "tutor_verified.tmpl", data)
+		err := app.mailer.Send(tutor.Email, locale, mailer.TutorVerifiedTemplate, data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 		}
 	})
 
@@ -30,7 +30,7 @@ func (app *application) uploadUserPhotoHandler(w http.ResponseWriter, r *http.Re
 	v := validator.New()
 	data.ValidateImage(v, fileHeader.Filename, fileHeader)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -75,7 +75,7 @@ func (app *application) createUserPhotoHandler(w http.ResponseWriter, r *http.Re
 	})
 
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -129,7 +129,7 @@ func (app *application) updateUserPhotoHandler(w http.ResponseWriter, r *http.Re
 	v := validator.New()
 	data.ValidateImage(v, fileHeader.Filename, fileHeader)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
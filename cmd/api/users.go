@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/araromirichard/internal/data"
+	"github.com/araromirichard/internal/mailer"
 	"github.com/araromirichard/internal/validator"
 )
 
@@ -67,7 +69,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	v := validator.New()
 	data.ValidateUser(v, user)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -75,8 +77,8 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("email", "validation.email_taken")
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -89,21 +91,22 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	app.background(func() {
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"firstName":       user.FirstName,
 			"logoURL":         "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
 		}
 		if user.Role == "student" {
-			err = app.mailer.Send(user.Email, "student_welcome.tmpl", data)
+			err = app.mailer.Send(user.Email, locale, mailer.StudentWelcomeTemplate, data)
 		}
 
 		if user.Role == "tutor" {
-			err = app.mailer.Send(user.Email, "tutor_welcome.tmpl", data)
+			err = app.mailer.Send(user.Email, locale, mailer.TutorWelcomeTemplate, data)
 		}
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 		}
 	})
 
@@ -155,6 +158,11 @@ func (app *application) initAdminUser(firstName, lastName, email, password strin
 		app.logger.PrintError(err, nil)
 		return fmt.Errorf("failed to grant admin permissions: %w", err)
 	}
+	app.writeAudit(&data.AuditEvent{
+		Action:     "permission.grant",
+		EntityType: "user",
+		EntityID:   strconv.FormatInt(user.ID, 10),
+	}, nil, map[string]interface{}{"codes": []string{"admin:access"}})
 
 	app.logger.PrintInfo("Admin user created successfully.", nil)
 	return nil
@@ -178,12 +186,18 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 	v := validator.New()
 
 	// Validate the input fields
-	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
-	v.Check(len(input.Password) >= 8, "password", "must be at least 8 characters long")
+	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "validation.email")
+	v.Check(len(input.Password) >= 8, "password", "validation.min_chars", "n", "8")
 
 	// If there are any validation errors, send a bad request response
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Turn the attempt away if there have been too many failed logins for this email
+	// or from this IP address recently
+	if !app.loginAllowed(w, r, input.Email) {
 		return
 	}
 
@@ -192,6 +206,7 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordLoginFailure(r, input.Email, nil)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -216,12 +231,32 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if !match {
+		app.recordLoginFailure(r, input.Email, user)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Generate a new authentication token
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// Users with two-factor authentication enabled have to complete a second step
+	// before they get their tokens. The login only counts as successful once they have.
+	challenge, err := app.newMFAChallenge(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if challenge != nil {
+		app.mfaChallengeResponse(w, r, challenge)
+		return
+	}
+
+	app.recordLoginSuccess(r, input.Email)
+
+	// Start a new session and generate its authentication and refresh tokens
+	token, refreshToken, err := app.startSession(r, user, false)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -229,8 +264,8 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 
 	message := "User logged in successfully"
 
-	// Respond with the user data and the authentication token
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": message, "user": user, "token": token}, nil)
+	// Respond with the user data and the tokens
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message, "user": user, "token": token, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -271,7 +306,7 @@ func (app *application) ListUsersHandler(w http.ResponseWriter, r *http.Request)
 
 	// Validate the filters
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -355,6 +390,7 @@ func (app *application) UpdateUserByIdHandler(w http.ResponseWriter, r *http.Req
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	before := userAuditSnapshot(user)
 	if input.FirstName != "" {
 		user.FirstName = input.FirstName
 	}
@@ -383,9 +419,52 @@ func (app *application) UpdateUserByIdHandler(w http.ResponseWriter, r *http.Req
 	if input.Gender != nil {
 		user.Gender = input.Gender
 	}
+
+	// Address fields are optional individually, so only the ones sent are changed. The
+	// result goes through the same ValidateAddress as registration.
+	addressChanged := input.StreetAddress1 != nil || input.StreetAddress2 != nil || input.City != nil ||
+		input.State != nil || input.Country != nil || input.Zipcode != nil
+	if addressChanged {
+		if user.Address == nil {
+			user.Address = &data.Address{UserID: user.ID}
+		}
+		if input.StreetAddress1 != nil {
+			user.Address.StreetAddress1 = *input.StreetAddress1
+		}
+		if input.StreetAddress2 != nil {
+			user.Address.StreetAddress2 = *input.StreetAddress2
+		}
+		if input.City != nil {
+			user.Address.City = *input.City
+		}
+		if input.State != nil {
+			user.Address.State = *input.State
+		}
+		if input.Country != nil {
+			user.Address.Country = *input.Country
+		}
+		if input.Zipcode != nil {
+			user.Address.Zipcode = *input.Zipcode
+		}
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, user.Email)
+	v.Check(validator.In(user.Role, "admin", "tutor", "student"), "role", "validation.one_of", "values", "admin, tutor or student")
+	if addressChanged {
+		data.ValidateAddress(v.Field("address"), user.Address)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
 	err = app.models.Users.UpdateUser(user)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "validation.email_taken")
+			app.failedValidationResponse(w, r, v)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -394,6 +473,17 @@ func (app *application) UpdateUserByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if addressChanged {
+		user.Address.UserID = user.ID
+		err = app.models.Address.Save(user.Address)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.recordAudit(r, "user.update", "user", strconv.FormatInt(user.ID, 10), before, userAuditSnapshot(user))
+
 	message := "User updated successfully"
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": message, "user": user}, nil)
 	if err != nil {
@@ -410,6 +500,17 @@ func (app *application) DeleteUserByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	user, err := app.models.Users.GetUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.models.Users.Delete(id)
 	if err != nil {
 		switch {
@@ -421,7 +522,137 @@ func (app *application) DeleteUserByIdHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// the user's images on Cloudinary were queued for deletion along with their rows
+	app.background(app.deleteQueuedAssets)
+
+	app.recordAudit(r, "user.delete", "user", strconv.FormatInt(id, 10), userAuditSnapshot(user), nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "User deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suspend a user account, optionally until a given date
+func (app *application) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until,omitempty"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateSuspension(v, input.Reason, input.Until)
+	v.Check(id != app.contextGetUser(r).ID, "id", "validation.suspend_self")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user, err := app.models.Users.GetUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := userAuditSnapshot(user)
+
+	err = app.models.Users.Suspend(user, input.Reason, input.Until)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Revoke every session so the suspension takes effect on every device at once.
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "user.suspend", "user", strconv.FormatInt(user.ID, 10), before, userAuditSnapshot(user))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "User suspended successfully", "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// lift a suspension from a user account
+func (app *application) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.getRequestID(r)
+	if err != nil {
+		app.NotFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.NotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := userAuditSnapshot(user)
+
+	err = app.models.Users.Unsuspend(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, "user.unsuspend", "user", strconv.FormatInt(user.ID, 10), before, userAuditSnapshot(user))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "User unsuspended successfully", "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userAuditSnapshot captures the admin-editable fields of a user for the audit log.
+// Personal data such as the user's email, name, date of birth and gender is left out, as
+// the audit log is kept long after the account is erased: the ID is enough to identify them.
+func userAuditSnapshot(user *data.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                user.ID,
+		"role":              user.Role,
+		"activated":         user.Activated,
+		"suspended_at":      user.SuspendedAt,
+		"suspended_until":   user.SuspendedUntil,
+		"suspension_reason": user.SuspensionReason,
+	}
 }
+
 func (app *application) GetUserByRoleHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the role from the query parameters
 	role := r.URL.Query().Get("role")
@@ -446,7 +677,7 @@ func (app *application) GetUserByRoleHandler(w http.ResponseWriter, r *http.Requ
 	input.Filters.SortSafeList = []string{"id", "first_name", "last_name", "email", "-id", "-first_name", "-last_name", "-email"}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -500,14 +731,15 @@ func (app *application) ForgotPasswordHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	// Send password reset email
-	app.background(func() {
+	locale := app.userLocale(r, user)
+	app.backgroundForRequest(r, func() {
 		data := map[string]interface{}{
 			"resetToken": token.Plaintext,
 			"firstName":  user.FirstName,
 		}
-		err = app.mailer.Send(user.Email, "password_reset.tmpl", data)
+		err = app.mailer.Send(user.Email, locale, mailer.PasswordResetTemplate, data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 		}
 	})
 
@@ -539,7 +771,7 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -547,8 +779,8 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "Invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("token", "validation.invalid_activation_token")
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -573,6 +805,13 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Whoever knew the old password may still be signed in, so log out everywhere.
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	env := envelope{"message": "Password reset successful"}
 	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
@@ -580,6 +819,88 @@ func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+// change the password of the authenticated user, who has to confirm their current one.
+// Every other session is signed out.
+func (app *application) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "validation.required")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+	v.Check(input.NewPassword == input.ConfirmPassword, "confirm_password", "validation.match", "field", "new_password")
+	v.Check(input.NewPassword != input.CurrentPassword, "new_password", "validation.password_unchanged")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	match, err := user.Password.Match(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("current_password", "validation.incorrect")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Keep the current session signed in and log out everywhere else. A token issued
+	// before sessions existed can't be told apart from the others, so it is revoked too.
+	var keepSessionID int64
+	if session := app.contextGetSession(r); session != nil {
+		keepSessionID = session.ID
+	}
+	err = app.models.Sessions.DeleteOthersForUser(user.ID, keepSessionID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Any outstanding reset links were sent for the old password.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoTokensFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, "user.password_change", "user", strconv.FormatInt(user.ID, 10), nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Password changed successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // // activate user
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
@@ -594,7 +915,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -602,8 +923,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "Invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("token", "validation.invalid_activation_token")
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, fmt.Errorf("error getting token: %w", err))
 		}
@@ -675,7 +996,7 @@ func (app *application) resendActivationTokenHandler(w http.ResponseWriter, r *h
 	// Validate the plaintext token.
 	v := validator.New()
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// retrieve the details of the user associated with the activation token
@@ -684,8 +1005,8 @@ func (app *application) resendActivationTokenHandler(w http.ResponseWriter, r *h
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "Invalid or Expired Activation Token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddError("email", "validation.invalid_activation_token")
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -703,7 +1024,7 @@ func (app *application) resendActivationTokenHandler(w http.ResponseWriter, r *h
 		"firstName":       user.FirstName,
 		"logoURL":         "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
 	}
-	err = app.mailer.Send(user.Email, "verify_email.tmpl", data)
+	err = app.mailer.Send(user.Email, app.userLocale(r, user), mailer.VerifyEmailTemplate, data)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/araromirichard/internal/validator"
 )
 
 // Address represents a user's address
@@ -19,6 +23,69 @@ type Address struct {
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+// ValidateAddress checks an address. Pass v.Field("address") when it is part of a user.
+func ValidateAddress(v *validator.Validator, address *Address) {
+	v.Check(address.StreetAddress1 != "", "street_address_1", "validation.required")
+	v.Check(validator.MaxRunes(address.StreetAddress1, 200), "street_address_1", "validation.max_chars", "n", "200")
+	v.Check(validator.MaxRunes(address.StreetAddress2, 200), "street_address_2", "validation.max_chars", "n", "200")
+	v.Check(address.City != "", "city", "validation.required")
+	v.Check(validator.MaxRunes(address.City, 100), "city", "validation.max_chars", "n", "100")
+	v.Check(validator.MaxRunes(address.State, 100), "state", "validation.max_chars", "n", "100")
+	v.Check(validator.MaxRunes(address.Zipcode, 20), "zipcode", "validation.max_chars", "n", "20")
+	v.Check(validator.CountryCode(address.Country), "country", "validation.country", "example", "NG")
+}
+
 type AddressModel struct {
 	DB *sql.DB
 }
+
+// Save stores a user's address, inserting it if the user doesn't have one yet
+func (m AddressModel) Save(address *Address) error {
+	if address.ID == 0 {
+		return m.insert(address)
+	}
+
+	query := `
+		UPDATE addresses
+		SET street_address_1 = $1, street_address_2 = $2, city = $3, state = $4, zipcode = $5,
+			country = $6, updated_at = NOW(), version = version + 1
+		WHERE id = $7 AND user_id = $8
+		RETURNING updated_at`
+
+	args := []interface{}{
+		address.StreetAddress1, address.StreetAddress2, address.City, address.State, address.Zipcode,
+		address.Country, address.ID, address.UserID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&address.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m AddressModel) insert(address *Address) error {
+	query := `
+		INSERT INTO addresses (user_id, street_address_1, street_address_2, city, state, zipcode, country)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`
+
+	args := []interface{}{
+		address.UserID, address.StreetAddress1, address.StreetAddress2, address.City, address.State,
+		address.Zipcode, address.Country,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&address.ID, &address.CreatedAt, &address.UpdatedAt)
+}
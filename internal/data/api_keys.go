@@ -0,0 +1,503 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, so they are easy to recognise in config files and
+// secret scanners
+const APIKeyPrefix = "ivw_"
+
+// apiKeyTouchInterval is how often a key's last_used_at is updated
+const apiKeyTouchInterval = time.Minute
+
+var ErrDuplicateServiceAccountName = errors.New("duplicate service account name")
+
+// ServiceAccount is a non-human user that authenticates with API keys instead of a password
+type ServiceAccount struct {
+	ID          int64     `json:"id"` // the id of the service account's user
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedBy   *int64    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// APIKey is a long-lived credential for a service account. The requests it authenticates
+// are limited to its own permissions, whatever the service account holds.
+type APIKey struct {
+	ID               int64       `json:"id"`
+	ServiceAccountID int64       `json:"service_account_id"`
+	Name             string      `json:"name"`
+	Prefix           string      `json:"prefix"`
+	Plaintext        string      `json:"key,omitempty"` // only set when the key is created
+	Permissions      Permissions `json:"permissions"`
+	AllowedIPs       []string    `json:"allowed_ips"`
+	CreatedAt        time.Time   `json:"created_at"`
+	LastUsedAt       *time.Time  `json:"last_used_at"`
+	ExpiresAt        *time.Time  `json:"expires_at"`
+	RevokedAt        *time.Time  `json:"revoked_at"`
+	ReplacedBy       *int64      `json:"replaced_by,omitempty"`
+}
+
+// Active reports whether the key can still be used
+func (k *APIKey) Active() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(time.Now())
+}
+
+// AllowsIP reports whether requests may come from the given address. An empty allow-list
+// allows any address. Entries are single addresses or CIDR ranges.
+func (k *APIKey) AllowsIP(address string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidateServiceAccount(v *validator.Validator, account *ServiceAccount) {
+	v.Check(account.Name != "", "name", "validation.required")
+	v.Check(len(account.Name) <= 50, "name", "validation.max_bytes", "n", "50")
+	v.Check(validator.Matches(account.Name, serviceAccountNameRX), "name", "validation.slug")
+	v.Check(validator.MaxRunes(account.Description, 500), "description", "validation.max_chars", "n", "500")
+}
+
+var serviceAccountNameRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, known Permissions) {
+	v.Check(key.Name != "", "name", "validation.required")
+	v.Check(validator.MaxRunes(key.Name, 100), "name", "validation.max_chars", "n", "100")
+	ValidatePermissionCodes(v, key.Permissions, known)
+	v.Check(validator.Unique(key.AllowedIPs), "allowed_ips", "validation.no_duplicates")
+	for i, allowed := range key.AllowedIPs {
+		_, _, err := net.ParseCIDR(allowed)
+		v.Index("allowed_ips", i).Check(err == nil || net.ParseIP(allowed) != nil, "", "validation.ip")
+	}
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "validation.future")
+	}
+}
+
+// generateAPIKey returns a new key made of the fixed prefix, an identifying prefix and a
+// random secret, such as "ivw_abcd2345_..."
+func generateAPIKey() (plaintext, prefix string, hash []byte, err error) {
+	random := make([]byte, 25)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random))
+	prefix = encoded[:8]
+	plaintext = APIKeyPrefix + prefix + "_" + encoded[8:]
+
+	sum := sha256.Sum256([]byte(plaintext))
+	return plaintext, prefix, sum[:], nil
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// InsertServiceAccount creates the service account along with its user. The user gets
+// an unusable random password, so it can only authenticate with API keys.
+func (m APIKeyModel) InsertServiceAccount(account *ServiceAccount) error {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return err
+	}
+
+	var placeholder password
+	if err := placeholder.Set(base32.StdEncoding.EncodeToString(random)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (email, password, first_name, last_name, username, role, activated)
+		VALUES ($1, $2, $3, 'Service', $4, 'service', true)
+		RETURNING id`
+
+	email := account.Name + "@service.invalid"
+	err = tx.QueryRowContext(ctx, query, email, placeholder.hash, account.Name, "service-"+account.Name).Scan(&account.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateServiceAccountName
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO service_accounts (user_id, name, description, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+
+	err = tx.QueryRowContext(ctx, query, account.ID, account.Name, account.Description, account.CreatedBy).Scan(&account.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "service_accounts_name_key"`:
+			return ErrDuplicateServiceAccountName
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetServiceAccount returns the service account with the given id
+func (m APIKeyModel) GetServiceAccount(id int64) (*ServiceAccount, error) {
+	query := `
+		SELECT user_id, name, description, created_by, created_at
+		FROM service_accounts
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var account ServiceAccount
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&account.ID, &account.Name, &account.Description, &account.CreatedBy, &account.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &account, nil
+}
+
+// GetAllServiceAccounts lists every service account by name
+func (m APIKeyModel) GetAllServiceAccounts() ([]*ServiceAccount, error) {
+	query := `
+		SELECT user_id, name, description, created_by, created_at
+		FROM service_accounts
+		ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*ServiceAccount{}
+	for rows.Next() {
+		var account ServiceAccount
+		err := rows.Scan(&account.ID, &account.Name, &account.Description, &account.CreatedBy, &account.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// DeleteServiceAccount removes the service account's user, which removes the account and
+// its keys with it
+func (m APIKeyModel) DeleteServiceAccount(id int64) error {
+	query := `
+		DELETE FROM users
+		WHERE id = $1 AND role = 'service'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Insert generates a new key for the service account, setting its plaintext
+func (m APIKeyModel) Insert(key *APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.insert(ctx, m.DB, key)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (m APIKeyModel) insert(ctx context.Context, db queryRower, key *APIKey) error {
+	plaintext, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+
+	query := `
+		INSERT INTO api_keys (service_account_id, name, prefix, hash, permissions, allowed_ips, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	args := []interface{}{key.ServiceAccountID, key.Name, prefix, hash, pq.Array([]string(key.Permissions)), pq.Array(key.AllowedIPs), key.ExpiresAt}
+	err = db.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = plaintext
+	key.Prefix = prefix
+	return nil
+}
+
+const apiKeyColumns = `id, service_account_id, name, prefix, permissions, allowed_ips, created_at, last_used_at, expires_at, revoked_at, replaced_by`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID, &key.ServiceAccountID, &key.Name, &key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)), pq.Array(&key.AllowedIPs),
+		&key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt, &key.ReplacedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Get returns one of the service account's keys
+func (m APIKeyModel) Get(serviceAccountID, id int64) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1 AND service_account_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, id, serviceAccountID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetForPlaintext looks a key up by its plaintext. Revoked and expired keys are returned
+// too, so the caller can tell them apart from keys that never existed.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetAllForServiceAccount lists the service account's keys, newest first
+func (m APIKeyModel) GetAllForServiceAccount(serviceAccountID int64) ([]*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE service_account_id = $1 ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Touch records that the key was just used. It only writes when last_used_at is older
+// than apiKeyTouchInterval, so busy integrations don't update the row on every request,
+// and reports whether it did, which is the first use of the key in the interval.
+func (m APIKeyModel) Touch(key *APIKey) (bool, error) {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < apiKeyTouchInterval {
+		return false, nil
+	}
+
+	// the condition is repeated in the query so that concurrent requests, possibly on
+	// other instances, only touch the key once
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - $2 * interval '1 second')
+		RETURNING last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key.ID, apiKeyTouchInterval.Seconds()).Scan(&key.LastUsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Revoke stops the key from being used, returning ErrRecordNotFound if it was already revoked
+func (m APIKeyModel) Revoke(key *APIKey) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING revoked_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key.ID).Scan(&key.RevokedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rotate replaces the key with a new one that has the same name, permissions and IP
+// allow-list. The old key keeps working for the grace period, so the integration can be
+// switched over without downtime, and is then expired.
+func (m APIKeyModel) Rotate(old *APIKey, grace time.Duration) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key := &APIKey{
+		ServiceAccountID: old.ServiceAccountID,
+		Name:             old.Name,
+		Permissions:      old.Permissions,
+		AllowedIPs:       old.AllowedIPs,
+		ExpiresAt:        old.ExpiresAt,
+	}
+
+	err = m.insert(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE api_keys
+		SET replaced_by = $1, expires_at = LEAST(COALESCE(expires_at, 'infinity'), $2)
+		WHERE id = $3 AND revoked_at IS NULL AND replaced_by IS NULL
+		RETURNING expires_at, replaced_by`
+
+	err = tx.QueryRowContext(ctx, query, key.ID, time.Now().Add(grace), old.ID).Scan(&old.ExpiresAt, &old.ReplacedBy)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AssetDeletion is an image queued to be destroyed on Cloudinary
+type AssetDeletion struct {
+	ID        int64
+	PublicID  string
+	CreatedAt time.Time
+}
+
+type AssetDeletionModel struct {
+	DB *sql.DB
+}
+
+// GetQueued returns up to limit queued deletions, oldest first
+func (m AssetDeletionModel) GetQueued(limit int) ([]*AssetDeletion, error) {
+	query := `
+		SELECT id, public_id, created_at
+		FROM asset_deletions
+		ORDER BY id
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []*AssetDeletion{}
+	for rows.Next() {
+		var deletion AssetDeletion
+		err := rows.Scan(&deletion.ID, &deletion.PublicID, &deletion.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, &deletion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deletions, nil
+}
+
+// Done removes a deletion from the queue once the image has been destroyed
+func (m AssetDeletionModel) Done(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM asset_deletions WHERE id = $1`, id)
+	return err
+}
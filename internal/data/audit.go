@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditEvent records a single privileged action taken against an entity
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	ActorID    *int64          `json:"actor_id"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilters narrows down the audit events returned by GetAll
+type AuditFilters struct {
+	ActorID    *int64
+	EntityType string
+	EntityID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert appends a new event to the audit_events table
+func (m AuditModel) Insert(event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, entity_type, entity_id, snapshot_before, snapshot_after, ip, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []interface{}{
+		event.ActorID,
+		event.Action,
+		event.EntityType,
+		event.EntityID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+		event.IP,
+		event.RequestID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting audit event: %w", err)
+	}
+
+	return nil
+}
+
+// GetAll returns a page of audit events matching the given filters
+func (m AuditModel) GetAll(af AuditFilters, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER() AS total_count, id, actor_id, action, entity_type, entity_id,
+		       snapshot_before, snapshot_after, ip, request_id, created_at
+		FROM audit_events
+		WHERE ($1::bigint IS NULL OR actor_id = $1::bigint)
+		AND ($2 = '' OR entity_type = $2)
+		AND ($3 = '' OR entity_id = $3)
+		AND ($4::timestamptz IS NULL OR created_at >= $4::timestamptz)
+		AND ($5::timestamptz IS NULL OR created_at < $5::timestamptz)
+		ORDER BY %s %s, id DESC
+		LIMIT $6 OFFSET $7`, filters.SortColumn(), filters.SortDirection())
+
+	args := []interface{}{
+		af.ActorID,
+		af.EntityType,
+		af.EntityID,
+		af.From,
+		af.To,
+		filters.PageSize,
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var event AuditEvent
+		var actorID sql.NullInt64
+		var before, after []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&actorID,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&before,
+			&after,
+			&event.IP,
+			&event.RequestID,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if actorID.Valid {
+			event.ActorID = &actorID.Int64
+		}
+		event.Before = before
+		event.After = after
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
+
+// nullableJSON converts an empty json.RawMessage to a SQL NULL
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// email change statuses
+const (
+	EmailChangePending   = "pending"
+	EmailChangeConfirmed = "confirmed"
+	EmailChangeReverted  = "reverted"
+	EmailChangeCancelled = "cancelled"
+)
+
+// EmailChange is a request to move an account to a new email address
+type EmailChange struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	OldEmail    string     `json:"old_email"`
+	NewEmail    string     `json:"new_email"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	RevertedAt  *time.Time `json:"reverted_at,omitempty"`
+}
+
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// Insert records a new pending change, cancelling any earlier pending change for the
+// user and any other account's pending change to the same address that was requested
+// before staleBefore, whose confirmation link has expired. ErrDuplicateEmail is returned
+// if another account already has the new address or is waiting to move to it.
+func (m EmailChangeModel) Insert(change *EmailChange, staleBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE email_changes
+		SET status = 'cancelled'
+		WHERE status = 'pending' AND (user_id = $1 OR (new_email = $2 AND created_at < $3))`,
+		change.UserID, change.NewEmail, staleBefore)
+	if err != nil {
+		return err
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, change.NewEmail).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicateEmail
+	}
+
+	query := `
+		INSERT INTO email_changes (user_id, old_email, new_email)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, created_at`
+
+	err = tx.QueryRowContext(ctx, query, change.UserID, change.OldEmail, change.NewEmail).Scan(&change.ID, &change.Status, &change.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_email_changes_pending_new_email"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CancelStale cancels the pending changes requested before staleBefore, whose
+// confirmation links have expired, so they no longer hold on to the new address
+func (m EmailChangeModel) CancelStale(staleBefore time.Time) error {
+	query := `
+		UPDATE email_changes
+		SET status = 'cancelled'
+		WHERE status = 'pending' AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, staleBefore)
+	return err
+}
+
+// GetPendingForUser returns the user's change that is waiting for confirmation
+func (m EmailChangeModel) GetPendingForUser(userID int64) (*EmailChange, error) {
+	return m.getLatest(userID, `status = 'pending'`)
+}
+
+// GetConfirmedSinceForUser returns the user's most recent change that was requested after
+// since and has been confirmed. While there is one, its revert link may still be used.
+func (m EmailChangeModel) GetConfirmedSinceForUser(userID int64, since time.Time) (*EmailChange, error) {
+	return m.getLatest(userID, `status = 'confirmed' AND created_at > $2`, since)
+}
+
+// GetRevertibleForUser returns the change the revert link in the notice to the old
+// address undoes: the user's most recent change that is still pending, or that was
+// confirmed and requested after since. No further change can be requested while a
+// confirmed one is revertible, so the link always undoes the change it was sent for.
+func (m EmailChangeModel) GetRevertibleForUser(userID int64, since time.Time) (*EmailChange, error) {
+	return m.getLatest(userID, `(status = 'pending' OR (status = 'confirmed' AND created_at > $2))`, since)
+}
+
+func (m EmailChangeModel) getLatest(userID int64, condition string, args ...interface{}) (*EmailChange, error) {
+	query := `
+		SELECT id, user_id, old_email, new_email, status, created_at, confirmed_at, reverted_at
+		FROM email_changes
+		WHERE user_id = $1 AND ` + condition + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var change EmailChange
+	err := m.DB.QueryRowContext(ctx, query, append([]interface{}{userID}, args...)...).Scan(
+		&change.ID, &change.UserID, &change.OldEmail, &change.NewEmail, &change.Status,
+		&change.CreatedAt, &change.ConfirmedAt, &change.RevertedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &change, nil
+}
+
+// Confirm moves the user to the new address and marks the change confirmed
+func (m EmailChangeModel) Confirm(change *EmailChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setUserEmail(ctx, tx, change.UserID, change.OldEmail, change.NewEmail)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE email_changes
+		SET status = 'confirmed', confirmed_at = NOW()
+		WHERE id = $1
+		RETURNING status, confirmed_at`, change.ID).Scan(&change.Status, &change.ConfirmedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Revert undoes the change. A pending change is simply abandoned, while a confirmed one
+// moves the user back to their old address.
+func (m EmailChangeModel) Revert(change *EmailChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if change.Status == EmailChangeConfirmed {
+		err = setUserEmail(ctx, tx, change.UserID, change.NewEmail, change.OldEmail)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE email_changes
+		SET status = 'reverted', reverted_at = NOW()
+		WHERE id = $1
+		RETURNING status, reverted_at`, change.ID).Scan(&change.Status, &change.RevertedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setUserEmail moves the user from one address to another. It returns ErrEditConflict if
+// the user no longer has the address being moved from, and ErrDuplicateEmail if another
+// account has taken the address being moved to.
+func setUserEmail(ctx context.Context, tx *sql.Tx, userID int64, from, to string) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET email = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND email = $3`, to, userID, from)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/i18n"
+	"github.com/araromirichard/internal/validator"
+)
+
+// EmailTemplate is a version of an email template that overrides the one embedded in the
+// binary, so the wording of an email can be changed without a redeploy
+type EmailTemplate struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"` // the embedded template it overrides, such as tutor_welcome.tmpl
+	Locale    string    `json:"locale"`
+	Version   int       `json:"version"`
+	Source    string    `json:"source"`
+	Active    bool      `json:"active"`
+	CreatedBy *int64    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type EmailTemplateModel struct {
+	DB *sql.DB
+}
+
+func ValidateEmailTemplate(v *validator.Validator, t *EmailTemplate) {
+	v.Check(i18n.Supported(t.Locale), "locale", "validation.one_of", "values", "en or fr")
+	v.Check(strings.TrimSpace(t.Source) != "", "source", "validation.required")
+	v.Check(len(t.Source) <= 100000, "source", "validation.max_bytes", "n", "100000")
+}
+
+// Insert saves a new version of a template and puts it in use in place of the one that
+// was active before. Versions are numbered per template and locale.
+func (m EmailTemplateModel) Insert(t *EmailTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deactivateEmailTemplate(ctx, tx, t.Name, t.Locale)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_templates (name, locale, version, source, active, created_by)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, true, $4
+		FROM email_templates
+		WHERE name = $1 AND locale = $2
+		RETURNING id, version, active, created_at`
+
+	err = tx.QueryRowContext(ctx, query, t.Name, t.Locale, t.Source, t.CreatedBy).Scan(&t.ID, &t.Version, &t.Active, &t.CreatedAt)
+	if err != nil {
+		switch {
+		// another version was saved at the same time
+		case strings.HasPrefix(err.Error(), "pq: duplicate key value violates unique constraint"):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetActive returns the version of a template that is in use for locale
+func (m EmailTemplateModel) GetActive(name, locale string) (*EmailTemplate, error) {
+	query := `
+		SELECT id, name, locale, version, source, active, created_by, created_at
+		FROM email_templates
+		WHERE name = $1 AND locale = $2 AND active`
+
+	return m.queryOne(query, name, locale)
+}
+
+// GetVersion returns one version of a template
+func (m EmailTemplateModel) GetVersion(name, locale string, version int) (*EmailTemplate, error) {
+	query := `
+		SELECT id, name, locale, version, source, active, created_by, created_at
+		FROM email_templates
+		WHERE name = $1 AND locale = $2 AND version = $3`
+
+	return m.queryOne(query, name, locale, version)
+}
+
+// GetAllVersions returns every version of a template in locale, newest first
+func (m EmailTemplateModel) GetAllVersions(name, locale string) ([]*EmailTemplate, error) {
+	query := `
+		SELECT id, name, locale, version, source, active, created_by, created_at
+		FROM email_templates
+		WHERE name = $1 AND locale = $2
+		ORDER BY version DESC`
+
+	return m.query(query, name, locale)
+}
+
+// GetAllActive returns the versions that are in use, across all templates and locales
+func (m EmailTemplateModel) GetAllActive() ([]*EmailTemplate, error) {
+	query := `
+		SELECT id, name, locale, version, source, active, created_by, created_at
+		FROM email_templates
+		WHERE active
+		ORDER BY name, locale`
+
+	return m.query(query)
+}
+
+// Activate puts an earlier version of a template back in use
+func (m EmailTemplateModel) Activate(t *EmailTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deactivateEmailTemplate(ctx, tx, t.Name, t.Locale)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE email_templates
+		SET active = true
+		WHERE id = $1
+		RETURNING active`
+
+	err = tx.QueryRowContext(ctx, query, t.ID).Scan(&t.Active)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Deactivate stops overriding a template in locale, so the embedded one is used again.
+// Its versions are kept and can be activated later.
+func (m EmailTemplateModel) Deactivate(name, locale string) error {
+	query := `
+		UPDATE email_templates
+		SET active = false
+		WHERE name = $1 AND locale = $2 AND active`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, name, locale)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func deactivateEmailTemplate(ctx context.Context, tx *sql.Tx, name, locale string) error {
+	query := `
+		UPDATE email_templates
+		SET active = false
+		WHERE name = $1 AND locale = $2 AND active`
+
+	_, err := tx.ExecContext(ctx, query, name, locale)
+	return err
+}
+
+func (m EmailTemplateModel) queryOne(query string, args ...interface{}) (*EmailTemplate, error) {
+	templates, err := m.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return templates[0], nil
+}
+
+func (m EmailTemplateModel) query(query string, args ...interface{}) ([]*EmailTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*EmailTemplate{}
+	for rows.Next() {
+		var t EmailTemplate
+		err := rows.Scan(&t.ID, &t.Name, &t.Locale, &t.Version, &t.Source, &t.Active, &t.CreatedBy, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
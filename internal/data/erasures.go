@@ -0,0 +1,311 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Account erasure statuses
+const (
+	ErasurePending   = "pending"
+	ErasureCancelled = "cancelled"
+	ErasureCompleted = "completed"
+)
+
+// ErasureGracePeriod is how long a user has to change their mind before their account is erased
+const ErasureGracePeriod = 30 * 24 * time.Hour
+
+var ErrErasurePending = errors.New("an erasure is already pending for this account")
+
+// AccountErasure is a user's request to have their account erased
+type AccountErasure struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	Status       string     `json:"status"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+type AccountErasureModel struct {
+	DB *sql.DB
+}
+
+// Insert schedules the user's account for erasure once the grace period has passed
+func (m AccountErasureModel) Insert(userID int64) (*AccountErasure, error) {
+	query := `
+		INSERT INTO account_erasures (user_id, scheduled_for)
+		VALUES ($1, $2)
+		RETURNING id, status, requested_at, scheduled_for`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	erasure := &AccountErasure{UserID: userID}
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now().Add(ErasureGracePeriod)).Scan(
+		&erasure.ID, &erasure.Status, &erasure.RequestedAt, &erasure.ScheduledFor,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_account_erasures_pending_user"`:
+			return nil, ErrErasurePending
+		default:
+			return nil, err
+		}
+	}
+
+	return erasure, nil
+}
+
+// GetPendingForUser returns the erasure waiting out its grace period for the user
+func (m AccountErasureModel) GetPendingForUser(userID int64) (*AccountErasure, error) {
+	query := `
+		SELECT id, user_id, status, requested_at, scheduled_for
+		FROM account_erasures
+		WHERE user_id = $1 AND status = 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var erasure AccountErasure
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&erasure.ID, &erasure.UserID, &erasure.Status, &erasure.RequestedAt, &erasure.ScheduledFor,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &erasure, nil
+}
+
+// Cancel stops a pending erasure from going ahead
+func (m AccountErasureModel) Cancel(erasure *AccountErasure) error {
+	query := `
+		UPDATE account_erasures
+		SET status = 'cancelled', cancelled_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING status, cancelled_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, erasure.ID).Scan(&erasure.Status, &erasure.CancelledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimDue claims pending erasures whose grace period has run out for the next lease, so
+// no other instance processes them at the same time. Erasures another instance has
+// claimed are skipped until its claim expires.
+func (m AccountErasureModel) ClaimDue(limit int, lease time.Duration) ([]*AccountErasure, error) {
+	query := `
+		UPDATE account_erasures
+		SET claimed_until = $2
+		WHERE id IN (
+			SELECT id
+			FROM account_erasures
+			WHERE status = 'pending' AND scheduled_for <= NOW()
+			AND (claimed_until IS NULL OR claimed_until < NOW())
+			ORDER BY scheduled_for
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, status, requested_at, scheduled_for`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	erasures := []*AccountErasure{}
+	for rows.Next() {
+		var erasure AccountErasure
+		err := rows.Scan(&erasure.ID, &erasure.UserID, &erasure.Status, &erasure.RequestedAt, &erasure.ScheduledFor)
+		if err != nil {
+			return nil, err
+		}
+		erasures = append(erasures, &erasure)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return erasures, nil
+}
+
+// CountDue returns the number of pending erasures whose grace period has run out
+func (m AccountErasureModel) CountDue() (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM account_erasures
+		WHERE status = 'pending' AND scheduled_for <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// Erase hard-deletes the personal data held for the user and anonymises their users row,
+// which is kept as a tombstone for the records we are required to retain. Their audit
+// events are kept without the personal data in their snapshots. The erasure is marked
+// completed in the same transaction. The user's photos are queued in asset_deletions, to
+// be destroyed on Cloudinary once the transaction has committed.
+func (m AccountErasureModel) Erase(erasure *AccountErasure) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the tombstone gets an unusable password so it can never be logged into
+	var placeholder password
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+	if err := placeholder.Set(base32.StdEncoding.EncodeToString(randomBytes)); err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the erasure may have been cancelled since it was claimed, in which case nothing is
+	// erased. Locking it keeps it from being cancelled while it is carried out.
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM account_erasures WHERE id = $1 FOR UPDATE`, erasure.ID).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if status != ErasurePending {
+		return ErrRecordNotFound
+	}
+
+	// students cascade to their guardians and tutors cascade to their languages,
+	// education, schedules, ratings, employment history and skills
+	deletes := []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM email_changes WHERE user_id = $1`,
+		`DELETE FROM login_attempts WHERE email = (SELECT email FROM users WHERE id = $1)`,
+		`DELETE FROM addresses WHERE user_id = $1`,
+		`INSERT INTO asset_deletions (public_id) SELECT public_id FROM user_photos WHERE user_id = $1 AND public_id <> ''`,
+		`DELETE FROM user_photos WHERE user_id = $1`,
+		`DELETE FROM guardians WHERE user_id = $1`,
+		`DELETE FROM students WHERE user_id = $1`,
+		`DELETE FROM tutors WHERE user_id = $1`,
+		`DELETE FROM users_permissions WHERE user_id = $1`,
+		`DELETE FROM users_roles WHERE user_id = $1`,
+		`DELETE FROM data_exports WHERE user_id = $1`,
+	}
+	for _, query := range deletes {
+		_, err = tx.ExecContext(ctx, query, erasure.UserID)
+		if err != nil {
+			return fmt.Errorf("erasing user %d: %w", erasure.UserID, err)
+		}
+	}
+
+	err = scrubAuditEvents(ctx, tx, erasure.UserID)
+	if err != nil {
+		return fmt.Errorf("erasing user %d: %w", erasure.UserID, err)
+	}
+
+	query := `
+		UPDATE users
+		SET email = $1, password = $2, first_name = 'Erased', last_name = 'User', username = 'erased-' || id,
+			activated = false, about_yourself = NULL, date_of_birth = NULL, gender = NULL,
+			suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL,
+			erased_at = NOW(), updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND erased_at IS NULL`
+
+	email := fmt.Sprintf("erased-%d@erased.invalid", erasure.UserID)
+	result, err := tx.ExecContext(ctx, query, email, placeholder.hash, erasure.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		UPDATE account_erasures
+		SET status = 'completed', completed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING status, completed_at`
+
+	err = tx.QueryRowContext(ctx, query, erasure.ID).Scan(&erasure.Status, &erasure.CompletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// scrubAuditEvents blanks the snapshots and IP addresses of the audit events about the
+// user, and the IP addresses of the events they performed, keeping the rest of the trail.
+// audit_events is append-only, and only lets this through while audit.erasure is set for
+// the transaction.
+func scrubAuditEvents(ctx context.Context, tx *sql.Tx, userID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('audit.erasure', 'on', true)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE audit_events
+		SET snapshot_before = NULL, snapshot_after = NULL, ip = ''
+		WHERE entity_type = 'user' AND entity_id = $1`, fmt.Sprint(userID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE audit_events
+		SET ip = ''
+		WHERE actor_id = $1 AND ip <> ''`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('audit.erasure', 'off', true)`)
+	return err
+}
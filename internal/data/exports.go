@@ -0,0 +1,483 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Data export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+var ErrExportPending = errors.New("an export is already being prepared for this user")
+
+// DataExport tracks a personal data export requested by a user
+type DataExport struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserDataArchive is the document handed to a user in response to a subject access
+// request. It gathers every record we hold that is linked to their account.
+type UserDataArchive struct {
+	GeneratedAt   time.Time      `json:"generated_at"`
+	Profile       *User          `json:"profile"`
+	Addresses     []Address      `json:"addresses"`
+	Student       *Student       `json:"student,omitempty"`
+	Guardians     []Guardian     `json:"guardians,omitempty"`
+	Tutor         *Tutor         `json:"tutor,omitempty"`
+	Photos        []UserPhoto    `json:"photos"`
+	Permissions   Permissions    `json:"permissions"`
+	Sessions      []*Session     `json:"sessions"`
+	LoginAttempts []LoginAttempt `json:"login_attempts"`
+	EmailChanges  []EmailChange  `json:"email_changes"`
+	MFA           *UserMFA       `json:"mfa,omitempty"`
+	Activity      []*AuditEvent  `json:"activity"`
+}
+
+type DataExportModel struct {
+	DB *sql.DB
+}
+
+// Insert queues a new pending export for the user, first failing any of their pending
+// exports queued before staleBefore, whose generation was cut short. ErrExportPending is
+// returned if the user already has an export being generated.
+func (m DataExportModel) Insert(userID int64, staleBefore time.Time) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE data_exports
+		SET status = 'failed', error = 'generation was interrupted', completed_at = NOW()
+		WHERE user_id = $1 AND status = 'pending' AND created_at < $2`,
+		userID, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO data_exports (user_id)
+		VALUES ($1)
+		RETURNING id, status, created_at`
+
+	export := &DataExport{UserID: userID}
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_data_exports_pending_user"`:
+			return nil, ErrExportPending
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// CountPending returns the number of exports that are still being generated
+func (m DataExportModel) CountPending() (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM data_exports
+		WHERE status = 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// MarkReady stores the generated archive and the time after which it can no longer be downloaded
+func (m DataExportModel) MarkReady(export *DataExport, archive []byte, expiresAt time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = 'ready', archive = $1, completed_at = NOW(), expires_at = $2
+		WHERE id = $3
+		RETURNING status, completed_at, expires_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, archive, expiresAt, export.ID).Scan(&export.Status, &export.CompletedAt, &export.ExpiresAt)
+}
+
+// MarkFailed records why an export could not be generated
+func (m DataExportModel) MarkFailed(export *DataExport, reason string) error {
+	query := `
+		UPDATE data_exports
+		SET status = 'failed', error = $1, completed_at = NOW()
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reason, export.ID)
+	if err == nil {
+		export.Status = ExportFailed
+	}
+	return err
+}
+
+// FailStale marks exports that have been pending since before cutoff as failed. Exports
+// are generated in the background of the instance that queued them, so one that is still
+// pending long after it was queued was cut short by a restart or crash and will never finish.
+func (m DataExportModel) FailStale(cutoff time.Time) (int64, error) {
+	query := `
+		UPDATE data_exports
+		SET status = 'failed', error = 'generation was interrupted', completed_at = NOW()
+		WHERE status = 'pending' AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetLatestReadyForUser returns the most recent unexpired export for the user along with its archive
+func (m DataExportModel) GetLatestReadyForUser(userID int64) (*DataExport, []byte, error) {
+	query := `
+		SELECT id, user_id, status, created_at, completed_at, expires_at, archive
+		FROM data_exports
+		WHERE user_id = $1 AND status = 'ready' AND expires_at > NOW()
+		ORDER BY created_at DESC
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var export DataExport
+	var archive []byte
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&export.ID, &export.UserID, &export.Status, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt, &archive,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &export, archive, nil
+}
+
+// Collect gathers everything we store about a user into a single archive
+func (m DataExportModel) Collect(userID int64) (*UserDataArchive, error) {
+	profile, err := UserModel{DB: m.DB}.GetUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting profile: %w", err)
+	}
+	if profile.Photo != nil && profile.Photo.URL == "" {
+		profile.Photo = nil
+	}
+
+	archive := &UserDataArchive{
+		GeneratedAt: time.Now().UTC(),
+		Profile:     profile,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	archive.Addresses, err = m.collectAddresses(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting addresses: %w", err)
+	}
+
+	archive.Student, archive.Guardians, err = m.collectStudent(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting student record: %w", err)
+	}
+
+	archive.Tutor, err = m.collectTutor(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting tutor record: %w", err)
+	}
+
+	archive.Photos, err = UserPhotoModel{DB: m.DB}.GetAllForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting photos: %w", err)
+	}
+
+	archive.Permissions, err = PermissionModel{DB: m.DB}.GetAllForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting permissions: %w", err)
+	}
+
+	archive.Sessions, err = SessionModel{DB: m.DB}.GetAllForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting sessions: %w", err)
+	}
+
+	archive.LoginAttempts, err = m.collectLoginAttempts(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting login attempts: %w", err)
+	}
+
+	archive.EmailChanges, err = m.collectEmailChanges(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting email changes: %w", err)
+	}
+
+	// the enrolment is exported without its secret
+	archive.MFA, err = MFAModel{DB: m.DB}.Get(userID)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, fmt.Errorf("error collecting two-factor enrolment: %w", err)
+	}
+
+	archive.Activity, err = m.collectActivity(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error collecting activity: %w", err)
+	}
+
+	return archive, nil
+}
+
+func (m DataExportModel) collectAddresses(ctx context.Context, userID int64) ([]Address, error) {
+	query := `
+		SELECT id, user_id, street_address_1, COALESCE(street_address_2, ''), city, state, zipcode, country, created_at, updated_at
+		FROM addresses
+		WHERE user_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []Address{}
+	for rows.Next() {
+		var a Address
+		err := rows.Scan(&a.ID, &a.UserID, &a.StreetAddress1, &a.StreetAddress2, &a.City, &a.State, &a.Zipcode, &a.Country, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+
+	return addresses, rows.Err()
+}
+
+func (m DataExportModel) collectStudent(ctx context.Context, userID int64) (*Student, []Guardian, error) {
+	query := `
+		SELECT id, user_id, ivw_id, family_background, education_level, created_at, updated_at, version
+		FROM students
+		WHERE user_id = $1`
+
+	var s Student
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&s.ID, &s.UserID, &s.IvwID, &s.FamilyBackground, &s.EducationLevel, &s.CreatedAt, &s.UpdatedAt, &s.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	query = `
+		SELECT id, student_id, first_name, last_name, relationship_to_student, phone, email, created_at, updated_at, version
+		FROM guardians
+		WHERE student_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, s.IvwID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	guardians := []Guardian{}
+	for rows.Next() {
+		var g Guardian
+		err := rows.Scan(&g.ID, &g.StudentID, &g.FirstName, &g.LastName, &g.RelationshipToStudent, &g.Phone, &g.Email, &g.CreatedAt, &g.UpdatedAt, &g.Version)
+		if err != nil {
+			return nil, nil, err
+		}
+		guardians = append(guardians, g)
+	}
+
+	return &s, guardians, rows.Err()
+}
+
+func (m DataExportModel) collectTutor(ctx context.Context, userID int64) (*Tutor, error) {
+	query := `
+		SELECT id, ivw_id, user_id, verification, rate_per_hour, eligible_to_work, criminal_record, timezone, created_at, updated_at, version
+		FROM tutors
+		WHERE user_id = $1`
+
+	var t Tutor
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&t.ID, &t.IvwID, &t.UserID, &t.Verification, &t.RatePerHour, &t.EligibleToWork, &t.CriminalRecord, &t.Timezone, &t.CreatedAt, &t.UpdatedAt, &t.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	tm := &TutorModel{DB: m.DB}
+
+	languages, err := tm.GetTutorLanguages(t.IvwID)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+	t.Languages = &languages
+
+	education, err := tm.GetTutorEducation(t.IvwID)
+	if err != nil {
+		return nil, err
+	}
+	t.Education = &education
+
+	schedule, err := tm.GetTutorSchedule(t.IvwID)
+	if err != nil {
+		return nil, err
+	}
+	t.Schedule = &schedule
+
+	employment, err := tm.GetTutorEmploymentHistory(t.IvwID)
+	if err != nil {
+		return nil, err
+	}
+	t.EmploymentHistory = &employment
+
+	skills, err := tm.GetTutorSkills(t.IvwID)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return nil, err
+	}
+	t.Skills = &skills
+
+	return &t, nil
+}
+
+// collectLoginAttempts returns the logins made with the user's email address. They are
+// recorded by address, so attempts made under an earlier address aren't included.
+func (m DataExportModel) collectLoginAttempts(ctx context.Context, userID int64) ([]LoginAttempt, error) {
+	query := `
+		SELECT ip, succeeded, created_at
+		FROM login_attempts
+		WHERE email = (SELECT email FROM users WHERE id = $1)
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		err := rows.Scan(&a.IP, &a.Succeeded, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+func (m DataExportModel) collectEmailChanges(ctx context.Context, userID int64) ([]EmailChange, error) {
+	query := `
+		SELECT id, user_id, old_email, new_email, status, created_at, confirmed_at, reverted_at
+		FROM email_changes
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []EmailChange{}
+	for rows.Next() {
+		var c EmailChange
+		err := rows.Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &c.Status, &c.CreatedAt, &c.ConfirmedAt, &c.RevertedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// collectActivity returns the audit trail of actions the user performed or that were
+// performed on their account. The IP address and snapshots of an event are only
+// included when the user performed it, so the export doesn't disclose the admin who
+// acted on the account.
+func (m DataExportModel) collectActivity(ctx context.Context, userID int64) ([]*AuditEvent, error) {
+	query := `
+		SELECT id, actor_id, action, entity_type, entity_id,
+			CASE WHEN actor_id = $1 THEN snapshot_before END,
+			CASE WHEN actor_id = $1 THEN snapshot_after END,
+			CASE WHEN actor_id = $1 THEN ip ELSE '' END,
+			request_id, created_at
+		FROM audit_events
+		WHERE actor_id = $1 OR (entity_type = 'user' AND entity_id = $2)
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, fmt.Sprint(userID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var actorID sql.NullInt64
+		var before, after []byte
+		err := rows.Scan(&event.ID, &actorID, &event.Action, &event.EntityType, &event.EntityID, &before, &after, &event.IP, &event.RequestID, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			event.ActorID = &actorID.Int64
+		}
+		event.Before = json.RawMessage(before)
+		event.After = json.RawMessage(after)
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
@@ -73,11 +73,11 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// checks for the page and page_size values
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.Page > 0, "page", "validation.greater_than_zero")
+	v.Check(f.Page <= 10_000_000, "page", "validation.maximum", "max", "10 million")
+	v.Check(f.PageSize > 0, "page_size", "validation.greater_than_zero")
+	v.Check(f.PageSize <= 100, "page_size", "validation.maximum", "max", "100")
 
 	// check that the sort parameter matches one of the values in our SortSafeList
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "validation.sort")
 }
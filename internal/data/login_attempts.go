@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginFailures summarises the recent failed logins for an email address and an IP address
+type LoginFailures struct {
+	ForEmail    int       // failures for the email since its last successful login
+	ForIP       int       // failures from the IP address, across all emails
+	LastFailure time.Time // the most recent failure for the email, zero if there are none
+}
+
+// LoginAttempt is a password or two-factor check made at login
+type LoginAttempt struct {
+	IP        string    `json:"ip"`
+	Succeeded bool      `json:"succeeded"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Insert records a login attempt
+func (m LoginAttemptModel) Insert(email, ip string, succeeded bool) error {
+	query := `
+		INSERT INTO login_attempts (email, ip, succeeded)
+		VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, ip, succeeded)
+	return err
+}
+
+// RecentFailures counts the failed logins made since the given time. Failures for the email
+// address only count from its last successful login, so a user who gets their password
+// right starts again from zero.
+func (m LoginAttemptModel) RecentFailures(email, ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE email = $1 AND created_at > COALESCE(
+				(SELECT MAX(created_at) FROM login_attempts WHERE email = $1 AND succeeded), '-infinity')),
+			COUNT(*) FILTER (WHERE ip = $2),
+			COALESCE(MAX(created_at) FILTER (WHERE email = $1), '-infinity')
+		FROM login_attempts
+		WHERE (email = $1 OR ip = $2) AND NOT succeeded AND created_at > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures LoginFailures
+	var last sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, email, ip, since).Scan(&failures.ForEmail, &failures.ForIP, &last)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+
+	if last.Valid && last.Time.After(since) {
+		failures.LastFailure = last.Time
+	}
+
+	return failures, nil
+}
+
+// ClearFailures forgets the failed logins for an email address, such as when an admin
+// unlocks the account
+func (m LoginAttemptModel) ClearFailures(email string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE email = $1 AND NOT succeeded`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
+
+// DeleteOlderThan removes attempts that are too old to count towards any limit
+func (m LoginAttemptModel) DeleteOlderThan(before time.Time) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+	return err
+}
+
+// Lock locks the user's account until the given time
+func (m LoginAttemptModel) Lock(userID int64, until time.Time) error {
+	query := `
+		INSERT INTO account_lockouts (user_id, locked_until)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET locked_until = EXCLUDED.locked_until, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, until)
+	return err
+}
+
+// LockedUntil returns when the lockout of the account with the given email address ends,
+// or ErrRecordNotFound when the account isn't locked
+func (m LoginAttemptModel) LockedUntil(email string) (time.Time, error) {
+	query := `
+		SELECT l.locked_until
+		FROM account_lockouts l
+		INNER JOIN users u ON u.id = l.user_id
+		WHERE u.email = $1 AND l.locked_until > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var until time.Time
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&until)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return until, nil
+}
+
+// Unlock lifts the user's lockout, returning ErrRecordNotFound if the account wasn't locked
+func (m LoginAttemptModel) Unlock(userID int64) error {
+	query := `
+		DELETE FROM account_lockouts
+		WHERE user_id = $1 AND locked_until > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
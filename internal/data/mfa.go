@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/araromirichard/internal/validator"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFACodeReused     = errors.New("two-factor code has already been used")
+)
+
+// how many recovery codes a user is given at a time
+const recoveryCodeCount = 10
+
+// UserMFA is a user's TOTP enrolment. It only protects logins once EnabledAt is set,
+// which happens when the user confirms it with a code from their authenticator app.
+// Secret is encrypted with totp.Seal when the API has a key configured, and must be
+// read with totp.Open.
+type UserMFA struct {
+	UserID       int64      `json:"-"`
+	Secret       string     `json:"-"`
+	LastUsedStep int64      `json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Enabled reports whether the enrolment has been confirmed
+func (m *UserMFA) Enabled() bool {
+	return m != nil && m.EnabledAt != nil
+}
+
+// MFAPolicy says whether users with the given account role must use two-factor authentication
+type MFAPolicy struct {
+	Role      string    `json:"role"`
+	Required  bool      `json:"required"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// the account roles a policy can be set for
+var MFAPolicyRoles = []string{"admin", "tutor", "student"}
+
+func ValidateMFAPolicyRole(v *validator.Validator, role string) {
+	v.Check(validator.In(role, MFAPolicyRoles...), "role", "validation.one_of", "values", "admin, tutor or student")
+}
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// Get returns the user's enrolment, confirmed or not
+func (m MFAModel) Get(userID int64) (*UserMFA, error) {
+	query := `
+		SELECT user_id, secret, last_used_step, enabled_at, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mfa UserMFA
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.LastUsedStep, &mfa.EnabledAt, &mfa.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// StartEnrolment stores a new secret for the user, replacing any unconfirmed one.
+// ErrMFAAlreadyEnabled is returned if the user has already confirmed an enrolment.
+func (m MFAModel) StartEnrolment(userID int64, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Enable confirms the user's enrolment, recording the time step of the code used to confirm it
+func (m MFAModel) Enable(userID, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// UseStep records that the code for the given time step has been used. Codes can't be
+// used twice, so ErrMFACodeReused is returned for a step at or before the last one.
+func (m MFAModel) UseStep(userID, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrMFACodeReused
+	}
+
+	return nil
+}
+
+// Disable removes the user's enrolment and recovery codes
+func (m MFAModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes generates a new set of recovery codes for the user, invalidating
+// the old ones. The plaintext codes are returned so they can be shown to the user once.
+func (m MFAModel) ReplaceRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash := hashRecoveryCode(code)
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used, returning
+// ErrRecordNotFound if it doesn't match an unused code
+func (m MFAModel) UseRecoveryCode(userID int64, code string) error {
+	hash := hashRecoveryCode(code)
+
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func (m MFAModel) RemainingRecoveryCodes(userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM mfa_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// GetPolicies returns the policy of every account role, including roles that have never been set
+func (m MFAModel) GetPolicies() ([]*MFAPolicy, error) {
+	query := `
+		SELECT role, required, updated_at
+		FROM mfa_role_policies`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]*MFAPolicy)
+	for rows.Next() {
+		var policy MFAPolicy
+		err := rows.Scan(&policy.Role, &policy.Required, &policy.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		stored[policy.Role] = &policy
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	policies := make([]*MFAPolicy, 0, len(MFAPolicyRoles))
+	for _, role := range MFAPolicyRoles {
+		policy, ok := stored[role]
+		if !ok {
+			policy = &MFAPolicy{Role: role}
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// IsRequired reports whether users with the given account role must use two-factor authentication
+func (m MFAModel) IsRequired(role string) (bool, error) {
+	query := `
+		SELECT required
+		FROM mfa_role_policies
+		WHERE role = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var required bool
+	err := m.DB.QueryRowContext(ctx, query, role).Scan(&required)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return required, nil
+}
+
+// SetPolicy creates or updates the policy for an account role
+func (m MFAModel) SetPolicy(policy *MFAPolicy) error {
+	query := `
+		INSERT INTO mfa_role_policies (role, required)
+		VALUES ($1, $2)
+		ON CONFLICT (role) DO UPDATE
+		SET required = EXCLUDED.required, updated_at = NOW()
+		RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, policy.Role, policy.Required).Scan(&policy.UpdatedAt)
+}
+
+// generateRecoveryCode returns a random code formatted as four groups of four
+// characters, such as "abcd-efgh-ijkl-mnop"
+func generateRecoveryCode() (string, error) {
+	random := make([]byte, 10)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// hashRecoveryCode normalises the code the way users tend to type it before hashing it
+func hashRecoveryCode(code string) [32]byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return sha256.Sum256([]byte(code))
+}
@@ -10,29 +10,53 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
-	ErrNoTokensFound = errors.New("no tokens found for the given user and scope")
+	ErrNoTokensFound  = errors.New("no tokens found for the given user and scope")
 )
 
 type Models struct {
-	Users       UserModel
-	Tutors      TutorModel
-	Students    StudentModel
-	UserPhoto   UserPhotoModel
-	Tokens      TokenModel
-	Permissions PermissionModel
-	Address     AddressModel
-	Guardians   GuardianModel
+	Users          UserModel
+	Tutors         TutorModel
+	Students       StudentModel
+	UserPhoto      UserPhotoModel
+	Tokens         TokenModel
+	Permissions    PermissionModel
+	Address        AddressModel
+	Guardians      GuardianModel
+	Audit          AuditModel
+	Stats          StatsModel
+	DataExports    DataExportModel
+	Erasures       AccountErasureModel
+	Roles          RoleModel
+	Sessions       SessionModel
+	MFA            MFAModel
+	LoginAttempts  LoginAttemptModel
+	EmailChanges   EmailChangeModel
+	APIKeys        APIKeyModel
+	EmailTemplates EmailTemplateModel
+	AssetDeletions AssetDeletionModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:       UserModel{DB: db},
-		Tutors:      TutorModel{DB: db},
-		Students:    StudentModel{DB: db},
-		UserPhoto:   UserPhotoModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Address:     AddressModel{DB: db},
-		Guardians:   GuardianModel{DB: db},
+		Users:          UserModel{DB: db},
+		Tutors:         TutorModel{DB: db},
+		Students:       StudentModel{DB: db},
+		UserPhoto:      UserPhotoModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Address:        AddressModel{DB: db},
+		Guardians:      GuardianModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		Stats:          StatsModel{DB: db},
+		DataExports:    DataExportModel{DB: db},
+		Erasures:       AccountErasureModel{DB: db},
+		Roles:          RoleModel{DB: db},
+		Sessions:       SessionModel{DB: db},
+		MFA:            MFAModel{DB: db},
+		LoginAttempts:  LoginAttemptModel{DB: db},
+		EmailChanges:   EmailChangeModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		EmailTemplates: EmailTemplateModel{DB: db},
+		AssetDeletions: AssetDeletionModel{DB: db},
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/araromirichard/internal/validator"
 	"github.com/lib/pq"
 )
 
@@ -26,26 +27,78 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
-// Define a GetAllForUser method that returns all permissions for a specific user in a Permission slice
+// Define a GetAllForUser method that returns all permissions for a specific user in a Permission slice.
+// This covers the permissions granted to the user directly as well as those granted through their roles.
 func (pm PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
-		SELECT permissions.code 
+		SELECT permissions.code
 		FROM permissions
 		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY code`
+
+	return pm.queryCodes(query, userID)
+}
 
+// GetAllForUserWithoutRole returns the permissions the user would have if the role were
+// taken away from them or stopped granting anything
+func (pm PermissionModel) GetAllForUserWithoutRole(userID, roleID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1 AND users_roles.role_id <> $2
+		ORDER BY code`
+
+	return pm.queryCodes(query, userID, roleID)
+}
+
+// GetDirectForUser returns only the permissions granted to the user individually, not through a role
+func (pm PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	return pm.queryCodes(query, userID)
+}
+
+// GetAll returns every permission code that can be granted
+func (pm PermissionModel) GetAll() (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	return pm.queryCodes(query)
+}
+
+func (pm PermissionModel) queryCodes(query string, args ...interface{}) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := pm.DB.QueryContext(ctx, query, userID)
+	rows, err := pm.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 
 	for rows.Next() {
 		var permission string
@@ -57,6 +110,10 @@ func (pm PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		permissions = append(permissions, permission)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return permissions, nil
 }
 
@@ -64,7 +121,8 @@ func (pm PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 func (pm PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -72,3 +130,102 @@ func (pm PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := pm.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// RemoveForUser revokes permissions granted to the user directly. Permissions the user holds
+// through a role are unaffected and must be revoked by removing the role.
+func (pm PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := pm.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// PermissionHolder is a user who holds a permission, either directly or through one or more roles
+type PermissionHolder struct {
+	UserID    int64    `json:"user_id"`
+	Email     string   `json:"email"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Role      string   `json:"role"`
+	Direct    bool     `json:"direct"`
+	Roles     []string `json:"roles"`
+}
+
+// GetHolders lists the users who hold the permission and how it was granted to them
+func (pm PermissionModel) GetHolders(code string) ([]*PermissionHolder, error) {
+	query := `
+		SELECT u.id, u.email, u.first_name, u.last_name, u.role,
+			bool_or(grants.direct), array_remove(array_agg(grants.role_name ORDER BY grants.role_name), NULL)
+		FROM (
+			SELECT users_permissions.user_id, true AS direct, NULL::text AS role_name
+			FROM users_permissions
+			INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+			WHERE permissions.code = $1
+			UNION ALL
+			SELECT users_roles.user_id, false, roles.name::text
+			FROM users_roles
+			INNER JOIN roles ON roles.id = users_roles.role_id
+			INNER JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+			WHERE permissions.code = $1
+		) AS grants
+		INNER JOIN users u ON u.id = grants.user_id
+		GROUP BY u.id
+		ORDER BY u.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := pm.DB.QueryContext(ctx, query, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holders := []*PermissionHolder{}
+	for rows.Next() {
+		var holder PermissionHolder
+		err := rows.Scan(&holder.UserID, &holder.Email, &holder.FirstName, &holder.LastName, &holder.Role, &holder.Direct, pq.Array(&holder.Roles))
+		if err != nil {
+			return nil, err
+		}
+		holders = append(holders, &holder)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holders, nil
+}
+
+// ValidatePermissionCodes checks that codes were provided and that each one is known
+func ValidatePermissionCodes(v *validator.Validator, codes []string, known Permissions) {
+	v.Check(len(codes) > 0, "permissions", "validation.at_least_one_permission")
+	v.Check(validator.Unique(codes), "permissions", "validation.no_duplicates")
+	for _, code := range codes {
+		if !known.Include(code) {
+			v.AddError("permissions", "validation.unknown_permission", "permission", code)
+			break
+		}
+	}
+}
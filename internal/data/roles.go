@@ -0,0 +1,322 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/araromirichard/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateRoleName = errors.New("duplicate role name")
+
+// Role is a named bundle of permissions that can be assigned to users
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Version     int         `json:"version"`
+}
+
+// RoleMember is a user who has been assigned a role
+type RoleMember struct {
+	UserID     int64     `json:"user_id"`
+	Email      string    `json:"email"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Role       string    `json:"role"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a role along with the permissions it grants
+func (m RoleModel) Insert(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt, &role.UpdatedAt, &role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	err = setRolePermissions(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get fetches a role and its permissions by ID
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT r.id, r.name, r.description, r.created_at, r.updated_at, r.version,
+			array_remove(array_agg(p.code ORDER BY p.code), NULL)
+		FROM roles r
+		LEFT JOIN roles_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE r.id = $1
+		GROUP BY r.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role Role
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.Version, pq.Array((*[]string)(&role.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetAll returns every role with its permissions
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.created_at, r.updated_at, r.version,
+			array_remove(array_agg(p.code ORDER BY p.code), NULL)
+		FROM roles r
+		LEFT JOIN roles_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.name`
+
+	return m.queryRoles(query)
+}
+
+// GetAllForUser returns the roles assigned to the user
+func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
+	query := `
+		SELECT r.id, r.name, r.description, r.created_at, r.updated_at, r.version,
+			array_remove(array_agg(p.code ORDER BY p.code), NULL)
+		FROM roles r
+		INNER JOIN users_roles ur ON ur.role_id = r.id
+		LEFT JOIN roles_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+		GROUP BY r.id
+		ORDER BY r.name`
+
+	return m.queryRoles(query, userID)
+}
+
+func (m RoleModel) queryRoles(query string, args ...interface{}) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.Version, pq.Array((*[]string)(&role.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Update changes a role's details and replaces the permissions it grants
+func (m RoleModel) Update(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE roles
+		SET name = $1, description = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description, role.ID, role.Version).Scan(&role.UpdatedAt, &role.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+
+	err = setRolePermissions(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a role, which also revokes it from every user it was assigned to
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddForUser assigns the role to the user. Assigning a role the user already has is a no-op.
+func (m RoleModel) AddForUser(userID, roleID int64) error {
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	return err
+}
+
+// RemoveForUser revokes the role from the user
+func (m RoleModel) RemoveForUser(userID, roleID int64) error {
+	query := `
+		DELETE FROM users_roles
+		WHERE user_id = $1 AND role_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetMembers lists the users the role has been assigned to
+func (m RoleModel) GetMembers(roleID int64) ([]*RoleMember, error) {
+	query := `
+		SELECT u.id, u.email, u.first_name, u.last_name, u.role, ur.created_at
+		FROM users_roles ur
+		INNER JOIN users u ON u.id = ur.user_id
+		WHERE ur.role_id = $1
+		ORDER BY ur.created_at, u.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*RoleMember{}
+	for rows.Next() {
+		var member RoleMember
+		err := rows.Scan(&member.UserID, &member.Email, &member.FirstName, &member.LastName, &member.Role, &member.AssignedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func setRolePermissions(ctx context.Context, tx *sql.Tx, role *Role) error {
+	query := `
+		INSERT INTO roles_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err := tx.ExecContext(ctx, query, role.ID, pq.Array([]string(role.Permissions)))
+	return err
+}
+
+func ValidateRole(v *validator.Validator, role *Role, known Permissions) {
+	v.Check(role.Name != "", "name", "validation.required")
+	v.Check(validator.MaxRunes(role.Name, 100), "name", "validation.max_chars", "n", "100")
+	v.Check(validator.MaxRunes(role.Description, 500), "description", "validation.max_chars", "n", "500")
+	ValidatePermissionCodes(v, role.Permissions, known)
+}
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// how stale last_used_at has to be before a request refreshes it, so an active
+// session isn't written to on every request
+const sessionTouchInterval = time.Minute
+
+// Session is a signed in device. Authentication tokens belong to a session, and
+// revoking the session revokes every token issued for it.
+type Session struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"-"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	MFAVerified bool      `json:"mfa_verified"` // signed in with a second factor
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert starts a new session for the user
+func (m SessionModel) Insert(session *Session) error {
+	query := `
+		INSERT INTO sessions (user_id, ip, user_agent, mfa_verified)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, session.UserID, session.IP, session.UserAgent, session.MFAVerified).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+}
+
+// GetForToken returns the session the token belongs to. Tokens issued before sessions
+// existed have none, in which case ErrRecordNotFound is returned.
+func (m SessionModel) GetForToken(tokenScope, tokenPlaintext string) (*Session, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT s.id, s.user_id, s.ip, s.user_agent, s.created_at, s.last_used_at, s.mfa_verified
+		FROM sessions s
+		INNER JOIN tokens ON tokens.session_id = s.id
+		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var session Session
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], tokenScope, time.Now()).Scan(
+		&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt, &session.MFAVerified,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// Touch records that the session was just used from the given IP address and user agent.
+// Recently touched sessions are left alone.
+func (m SessionModel) Touch(session *Session, ip, userAgent string) error {
+	if time.Since(session.LastUsedAt) < sessionTouchInterval {
+		return nil
+	}
+
+	query := `
+		UPDATE sessions
+		SET last_used_at = NOW(), ip = $1, user_agent = $2
+		WHERE id = $3
+		RETURNING last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, ip, userAgent, session.ID).Scan(&session.LastUsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	session.IP = ip
+	session.UserAgent = userAgent
+	return nil
+}
+
+// MarkMFAVerified records that the session has been confirmed with a second factor
+func (m SessionModel) MarkMFAVerified(session *Session) error {
+	query := `
+		UPDATE sessions
+		SET mfa_verified = true
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, session.ID)
+	if err != nil {
+		return err
+	}
+
+	session.MFAVerified = true
+	return nil
+}
+
+// GetAllForUser lists the user's sessions, most recently used first
+func (m SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, ip, user_agent, created_at, last_used_at, mfa_verified
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY last_used_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt, &session.MFAVerified)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete revokes one of the user's sessions along with its tokens
+func (m SessionModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteOthersForUser revokes every session the user has apart from the one given, so
+// they stay signed in on the device they are using. Authentication tokens issued before
+// sessions existed are removed as well.
+func (m SessionModel) DeleteOthersForUser(userID, keepSessionID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2 AND session_id IS NULL`, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAllForUser revokes every session the user has, logging them out everywhere.
+// Authentication tokens issued before sessions existed are removed as well.
+func (m SessionModel) DeleteAllForUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
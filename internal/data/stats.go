@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/araromirichard/internal/validator"
+)
+
+// StatsGranularities lists the periods the admin statistics can be grouped by
+var StatsGranularities = []string{"day", "week", "month"}
+
+// StatsRange is the reporting window and grouping used for the admin statistics
+type StatsRange struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Granularity string    `json:"granularity"`
+}
+
+// SignupCount is the number of users of a role who signed up in a period
+type SignupCount struct {
+	Period time.Time `json:"period"`
+	Role   string    `json:"role"`
+	Count  int       `json:"count"`
+}
+
+// ActivationStats summarises how many of the users who signed up activated their account
+type ActivationStats struct {
+	Total     int     `json:"total"`
+	Activated int     `json:"activated"`
+	Rate      float64 `json:"rate"`
+}
+
+// VerificationStats counts tutors by their verification status
+type VerificationStats struct {
+	Verified   int `json:"verified"`
+	Unverified int `json:"unverified"`
+}
+
+// SkillCount is the number of tutors offering a skill
+type SkillCount struct {
+	Skill  string `json:"skill"`
+	Tutors int    `json:"tutors"`
+}
+
+type StatsModel struct {
+	DB *sql.DB
+}
+
+// Signups returns the number of signups per period and role within the range
+func (m StatsModel) Signups(sr StatsRange) ([]SignupCount, error) {
+	query := `
+		SELECT date_trunc($1, created_at) AS period, role, count(*)
+		FROM users
+		WHERE created_at >= $2 AND created_at < $3
+		GROUP BY period, role
+		ORDER BY period, role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, sr.Granularity, sr.From, sr.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signups := []SignupCount{}
+	for rows.Next() {
+		var signup SignupCount
+		err := rows.Scan(&signup.Period, &signup.Role, &signup.Count)
+		if err != nil {
+			return nil, err
+		}
+		signups = append(signups, signup)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return signups, nil
+}
+
+// Activation returns the activation rate of users who signed up within the range
+func (m StatsModel) Activation(sr StatsRange) (ActivationStats, error) {
+	query := `
+		SELECT count(*), count(*) FILTER (WHERE activated)
+		FROM users
+		WHERE created_at >= $1 AND created_at < $2 AND role != 'admin'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stats ActivationStats
+	err := m.DB.QueryRowContext(ctx, query, sr.From, sr.To).Scan(&stats.Total, &stats.Activated)
+	if err != nil {
+		return ActivationStats{}, err
+	}
+
+	if stats.Total > 0 {
+		stats.Rate = float64(stats.Activated) / float64(stats.Total)
+	}
+
+	return stats, nil
+}
+
+// TutorsByVerification counts all tutors by verification status
+func (m StatsModel) TutorsByVerification() (VerificationStats, error) {
+	query := `
+		SELECT count(*) FILTER (WHERE verification), count(*) FILTER (WHERE NOT verification)
+		FROM tutors`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stats VerificationStats
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.Verified, &stats.Unverified)
+	if err != nil {
+		return VerificationStats{}, err
+	}
+
+	return stats, nil
+}
+
+// TopOfferedSkills returns the skills offered by the largest number of tutors. This is
+// supply rather than demand: searches aren't recorded yet, so the skills students search
+// for most can't be reported.
+func (m StatsModel) TopOfferedSkills(limit int) ([]SkillCount, error) {
+	query := `
+		SELECT lower(skill) AS skill, count(DISTINCT tutor_id) AS tutors
+		FROM tutor_skills, unnest(skills) AS skill
+		GROUP BY lower(skill)
+		ORDER BY tutors DESC, skill ASC
+		LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	skills := []SkillCount{}
+	for rows.Next() {
+		var skill SkillCount
+		err := rows.Scan(&skill.Skill, &skill.Tutors)
+		if err != nil {
+			return nil, err
+		}
+		skills = append(skills, skill)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return skills, nil
+}
+
+func ValidateStatsRange(v *validator.Validator, sr StatsRange) {
+	v.Check(validator.In(sr.Granularity, StatsGranularities...), "granularity", "validation.one_of", "values", "day, week or month")
+	v.CheckDateRange(sr.From, sr.To, "from", "to")
+	v.Check(sr.To.Sub(sr.From) <= 366*24*time.Hour, "to", "validation.range_too_long")
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"github.com/araromirichard/internal/validator"
 	"time"
@@ -15,14 +16,26 @@ const (
 	ScopeActivation = "activation"
 	ScopePasswordReset = "resetpassword"
 	ScopeAuthentication = "authentication"
+	ScopeDataExport = "dataexport"
+	ScopeRefresh = "refresh"
+	ScopeMFA = "mfa"
+	ScopeEmailChange = "emailchange"
+	ScopeEmailRevert = "emailrevert"
+	ScopeMagicLink = "magiclink"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that has already been
+// rotated is presented again, which means it has probably been stolen
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	SessionID int64     `json:"-"` // zero for tokens that don't belong to a session
+	Used      bool      `json:"-"` // set once a refresh token has been rotated
 }
 
 func generationToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -62,8 +75,8 @@ func generationToken(userID int64, ttl time.Duration, scope string) (*Token, err
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", "validation.required")
+	v.Check(len(tokenPlaintext) == 26, "token", "validation.exact_bytes", "n", "26")
 }
 
 // Define the token model
@@ -85,13 +98,28 @@ func (tm TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewForSession() creates a new token tied to a session, so it is revoked along with the session
+func (tm TokenModel) NewForSession(userID, sessionID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generationToken(userID, ttl, scope)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.SessionID = sessionID
+	err = tm.Insert(token)
+
+	return token, err
+}
+
 // Insert() adds the specific token to the token table
 func (tm TokenModel) Insert(token *Token) error {
 	query :=
-		`INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)
+		`INSERT INTO tokens (hash, user_id, expiry, scope, session_id)
+	VALUES ($1, $2, $3, $4, $5)
 	`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	sessionID := sql.NullInt64{Int64: token.SessionID, Valid: token.SessionID > 0}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, sessionID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -127,3 +155,148 @@ func (tm TokenModel) DeleteAllForUser(scope string, userID int64) error {
 
 	return nil
 }
+
+// Consume() deletes an unexpired token and returns the ID of the user it belonged to, so
+// that a single-use token can only be redeemed once even by concurrent requests. It
+// returns ErrRecordNotFound if there is no such token, or it has already been used.
+func (tm TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3
+	RETURNING user_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := tm.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// DeleteForPlaintext() deletes a single token, such as the one presented on logout
+func (tm TokenModel) DeleteForPlaintext(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND hash = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tm.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return err
+}
+
+// GetRefresh() looks up an unexpired refresh token, including ones that have already been rotated
+func (tm TokenModel) GetRefresh(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT user_id, expiry, session_id, used_at IS NOT NULL
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3 AND session_id IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+		Scope:     ScopeRefresh,
+	}
+
+	err := tm.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(&token.UserID, &token.Expiry, &token.SessionID, &token.Used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// Rotate() marks the refresh token as used and issues a new access and refresh token for
+// the same session. The session's previous access tokens are revoked. If the refresh
+// token was used in the meantime, ErrRefreshTokenReused is returned and nothing is issued.
+func (tm TokenModel) Rotate(refresh *Token, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	access, err := generationToken(refresh.UserID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err := generationToken(refresh.UserID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	access.SessionID = refresh.SessionID
+	next.SessionID = refresh.SessionID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE tokens
+	SET used_at = NOW()
+	WHERE hash = $1 AND scope = $2 AND used_at IS NULL
+	`, refresh.Hash, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM tokens
+	WHERE session_id = $1 AND scope = $2
+	`, refresh.SessionID, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, session_id)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+	for _, token := range []*Token{access, next} {
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.SessionID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, next, nil
+}
@@ -666,31 +666,58 @@ func (tm *TutorModel) GetTutorSkills(tutorID string) ([]string, error) {
 
 func ValidateTutor(v *validator.Validator, tutor *Tutor) {
 	//v.Check(tutor.IvwID != "", "TutorID", "cannot be empty")
-	v.Check(tutor.UserID != 0, "UserID", "cannot be 0")
-	v.Check(tutor.RatePerHour > 0, "RatePerHour", "must be greater than 0")
-	v.Check(tutor.Timezone != "", "Timezone", "cannot be empty")
+	v.Check(tutor.UserID != 0, "UserID", "validation.not_zero")
+	v.Check(tutor.RatePerHour > 0, "RatePerHour", "validation.greater_than", "value", "0")
+	v.Check(tutor.Timezone != "", "Timezone", "validation.not_empty")
+	v.Check(tutor.Timezone == "" || validator.Timezone(tutor.Timezone), "Timezone", "validation.timezone", "example", "Africa/Lagos")
 
 	ValidateTutorIvwID(v, tutor.IvwID)
 }
 
 // Seperate validation for IvwID so i can reuse it in other places
 func ValidateTutorIvwID(v *validator.Validator, tutorID string) {
-	v.Check(tutorID != "", "TutorID", "cannot be empty")
+	v.Check(tutorID != "", "TutorID", "validation.not_empty")
 }
 
+// ValidateTutorEducation checks an education entry. To check an entry in a list, pass
+// v.Index("education", i) so errors are reported as education[i].EndYear.
 func ValidateTutorEducation(v *validator.Validator, tutorEducation *Education) {
-	v.Check(tutorEducation.Course != "", "Course", "cannot be empty")
-	v.Check(tutorEducation.Institute != "", "Institute", "cannot be empty")
-	v.Check(tutorEducation.StartYear > 0, "StartYear", "must be greater than 0")
-	v.Check(tutorEducation.EndYear > tutorEducation.StartYear, "EndYear", "must be greater than StartYear")
+	v.Check(tutorEducation.Course != "", "Course", "validation.not_empty")
+	v.Check(validator.MaxRunes(tutorEducation.Course, 200), "Course", "validation.max_chars", "n", "200")
+	v.Check(tutorEducation.Institute != "", "Institute", "validation.not_empty")
+	v.Check(validator.MaxRunes(tutorEducation.Institute, 200), "Institute", "validation.max_chars", "n", "200")
+	v.Check(tutorEducation.StartYear > 0, "StartYear", "validation.greater_than", "value", "0")
+	v.Check(tutorEducation.EndYear > tutorEducation.StartYear, "EndYear", "validation.greater_than", "value", "StartYear")
 }
 
+// ValidateTutorEmploymentHistory checks an employment history entry. Entries in a list
+// are checked with v.Index, as in ValidateTutorEducation.
 func ValidateTutorEmploymentHistory(v *validator.Validator, tutorEmploymentHistory *EmploymentHistory) {
-	v.Check(tutorEmploymentHistory.Company != "", "Company", "cannot be empty")
-	v.Check(tutorEmploymentHistory.Position != "", "Position", "cannot be empty")
-	v.Check(!tutorEmploymentHistory.StartDate.IsZero(), "StartDate", "cannot be empty")
-	v.Check(!tutorEmploymentHistory.EndDate.IsZero(), "EndDate", "cannot be empty")
-	v.Check(tutorEmploymentHistory.StartDate.Before(tutorEmploymentHistory.EndDate), "EndDate", "must be after StartDate")
+	v.Check(tutorEmploymentHistory.Company != "", "Company", "validation.not_empty")
+	v.Check(validator.MaxRunes(tutorEmploymentHistory.Company, 200), "Company", "validation.max_chars", "n", "200")
+	v.Check(tutorEmploymentHistory.Position != "", "Position", "validation.not_empty")
+	v.Check(validator.MaxRunes(tutorEmploymentHistory.Position, 200), "Position", "validation.max_chars", "n", "200")
+	v.CheckDateRange(tutorEmploymentHistory.StartDate, tutorEmploymentHistory.EndDate, "StartDate", "EndDate")
+}
+
+// ValidateTutorLanguages checks each language, reporting errors as languages[i]
+func ValidateTutorLanguages(v *validator.Validator, languages []string) {
+	validateTutorList(v, "languages", languages)
+}
+
+// ValidateTutorSkills checks each skill, reporting errors as skills[i]
+func ValidateTutorSkills(v *validator.Validator, skills []string) {
+	validateTutorList(v, "skills", skills)
+}
+
+func validateTutorList(v *validator.Validator, key string, values []string) {
+	v.Check(len(values) > 0, key, "validation.at_least_one_entry")
+	v.Check(validator.Unique(values), key, "validation.no_duplicates")
+	for i, value := range values {
+		item := v.Index(key, i)
+		item.Check(value != "", "", "validation.not_empty")
+		item.Check(validator.MaxRunes(value, 50), "", "validation.max_chars", "n", "50")
+	}
 }
 
 // verify tutor by admin
@@ -741,14 +768,34 @@ func (tm *TutorModel) GetId(IvwID string) (int64, error) {
 	return userID, nil
 }
 
+// GetVerification returns the current verification status of a tutor
+func (tm *TutorModel) GetVerification(IvwID string) (bool, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	query := `
+		SELECT verification FROM tutors
+		WHERE ivw_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var verification bool
+	err := tm.DB.QueryRowContext(ctx, query, IvwID).Scan(&verification)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return false, ErrRecordNotFound
+		default:
+			return false, fmt.Errorf("error getting tutor verification: %w", err)
+		}
+	}
+	return verification, nil
+}
+
 func ValidateTutorRating(v *validator.Validator, tutorRating *Rating) {
-	v.Check(tutorRating.Rating >= 0 && tutorRating.Rating <= 5, "Rating", "must be between 0 and 5")
-	v.Check(tutorRating.Count >= 0, "Count", "must be greater than or equal to 0")
+	v.Check(tutorRating.Rating >= 0 && tutorRating.Rating <= 5, "Rating", "validation.between", "min", "0", "max", "5")
+	v.Check(tutorRating.Count >= 0, "Count", "validation.greater_or_equal", "value", "0")
 }
 
 func ValidateTutorSchedule(v *validator.Validator, tutorSchedule *Schedule) {
-	v.Check(tutorSchedule.Day != "", "Day", "cannot be empty")
-	v.Check(!tutorSchedule.StartTime.IsZero(), "StartTime", "cannot be empty")
-	v.Check(!tutorSchedule.EndTime.IsZero(), "EndTime", "cannot be empty")
-	v.Check(tutorSchedule.StartTime.Before(tutorSchedule.EndTime), "EndTime", "must be after StartTime")
+	v.Check(tutorSchedule.Day != "", "Day", "validation.not_empty")
+	v.CheckDateRange(tutorSchedule.StartTime, tutorSchedule.EndTime, "StartTime", "EndTime")
 }
@@ -110,8 +110,43 @@ func (m UserPhotoModel) Delete(id int64) error {
 	return nil
 }
 
+// GetAllForUser returns every photo stored for a user
+func (m UserPhotoModel) GetAllForUser(userID int64) ([]UserPhoto, error) {
+	query := `
+		SELECT id, user_id, photo_url, public_id, created_at, updated_at, version
+		FROM user_photos
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	photos := []UserPhoto{}
+	for rows.Next() {
+		var photo UserPhoto
+		err := rows.Scan(&photo.ID, &photo.UserID, &photo.URL, &photo.PublicID, &photo.CreatedAt, &photo.UpdatedAt, &photo.Version)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
 // ValidateUserPhoto validates the photo URL
 func ValidateUserPhoto(v *validator.Validator, photo *UserPhoto) {
-	v.Check(photo.URL != "", "photo_url", "must be provided")
-	v.Check(len(photo.URL) <= 500, "photo_url", "must not be more than 500 bytes long")
+	v.Check(photo.URL != "", "photo_url", "validation.required")
+	v.Check(len(photo.URL) <= 500, "photo_url", "validation.max_bytes", "n", "500")
+	v.Check(validator.URL(photo.URL), "photo_url", "validation.url")
 }
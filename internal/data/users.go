@@ -42,6 +42,15 @@ type User struct {
 	Photo         *UserPhoto `json:"photo,omitempty"` // optimistic locking
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// Suspension details, set when an admin suspends the account
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+	SuspensionReason *string    `json:"suspension_reason,omitempty"`
+
+	// Locale is the language the user has chosen for emails and API messages, empty
+	// when they haven't picked one
+	Locale string `json:"locale,omitempty"`
 }
 
 // Check if a User Instance is the AnonymousUser
@@ -49,6 +58,15 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// IsSuspended reports whether the account is currently suspended. A suspension without
+// an end date lasts until an admin lifts it.
+func (u *User) IsSuspended() bool {
+	if u.SuspendedAt == nil {
+		return false
+	}
+	return u.SuspendedUntil == nil || u.SuspendedUntil.After(time.Now())
+}
+
 // custom password type that holds the hash and plain text
 type password struct {
 	plaintext *string
@@ -220,7 +238,7 @@ func (m UserModel) GetAll(searchTerm string, classPreferences []string, filters
 			to_tsvector('simple', COALESCE(u.country, '')) @@ plainto_tsquery('simple', $1) OR
 			to_tsvector('simple', COALESCE(u.state, '')) @@ plainto_tsquery('simple', $1) OR
 			to_tsvector('simple', COALESCE(u.city, '')) @@ plainto_tsquery('simple', $1)
-		) AND ($2::boolean IS NULL OR u.activated = $2::boolean) AND u.role != 'admin'
+		) AND ($2::boolean IS NULL OR u.activated = $2::boolean) AND u.role != 'admin' AND u.erased_at IS NULL
 		ORDER BY %s %s, u.id ASC
 		LIMIT $3 OFFSET $4
 	`, filters.SortColumn(), filters.SortDirection())
@@ -306,7 +324,8 @@ func (m UserModel) GetUser(id int64) (*User, error) {
 	}
 
 	query := `
-		SELECT u.id, u.email, u.first_name, u.last_name, u.username, u.activated, u.role, u.about_yourself, u.date_of_birth, u.gender, u.created_at, u.updated_at, u.version,
+		SELECT u.id, u.email, u.password, u.first_name, u.last_name, u.username, u.activated, u.role, u.about_yourself, u.date_of_birth, u.gender, u.created_at, u.updated_at, u.version,
+			   u.suspended_at, u.suspended_until, u.suspension_reason, u.locale,
 			   up.photo_url AS photo_url, up.public_id, up.created_at AS photo_created_at, up.updated_at AS photo_updated_at
 		FROM users u
 		LEFT JOIN user_photos up ON u.id = up.user_id
@@ -326,6 +345,7 @@ func (m UserModel) GetUser(id int64) (*User, error) {
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
+		&user.Password.hash,
 		&user.FirstName,
 		&user.LastName,
 		&user.Username,
@@ -337,6 +357,10 @@ func (m UserModel) GetUser(id int64) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Version,
+		&user.SuspendedAt,
+		&user.SuspendedUntil,
+		&user.SuspensionReason,
+		&user.Locale,
 		&photoURL,
 		&photoPublicID,
 		&photoCreatedAt,
@@ -374,7 +398,8 @@ func (m UserModel) GetUser(id int64) (*User, error) {
 // Get User by email
 func (m UserModel) GetUserByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, email, password, first_name, last_name, username, activated, role, about_yourself, date_of_birth, gender, created_at, updated_at, version
+		SELECT id, email, password, first_name, last_name, username, activated, role, about_yourself, date_of_birth, gender, created_at, updated_at, version,
+			suspended_at, suspended_until, suspension_reason, locale
 		FROM users
 		WHERE email = $1`
 
@@ -383,7 +408,7 @@ func (m UserModel) GetUserByEmail(email string) (*User, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Password.hash, &user.FirstName, &user.LastName, &user.Username, &user.Activated, &user.Role, &user.AboutYourself, &user.DateOfBirth, &user.Gender, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Password.hash, &user.FirstName, &user.LastName, &user.Username, &user.Activated, &user.Role, &user.AboutYourself, &user.DateOfBirth, &user.Gender, &user.CreatedAt, &user.UpdatedAt, &user.Version, &user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason, &user.Locale)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -417,7 +442,7 @@ func (m UserModel) GetUserByRole(role string, searchTerm string, filters Filters
             to_tsvector('simple', COALESCE(u.first_name, '')) @@ plainto_tsquery('simple', $2) OR
             to_tsvector('simple', COALESCE(u.last_name, '')) @@ plainto_tsquery('simple', $2) OR
             to_tsvector('simple', COALESCE(u.username, '')) @@ plainto_tsquery('simple', $2)
-        )
+        ) AND u.erased_at IS NULL
         ORDER BY %s %s, u.id ASC
         LIMIT $3 OFFSET $4
     `, filters.SortColumn(), filters.SortDirection())
@@ -533,12 +558,104 @@ func (m UserModel) UpdateUser(user *User) error {
 	return nil
 }
 
+// Suspend marks the account as suspended with a reason and an optional end date
+func (m UserModel) Suspend(user *User, reason string, until *time.Time) error {
+	query := `
+		UPDATE users
+		SET suspended_at = NOW(), suspended_until = $1, suspension_reason = $2,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING suspended_at, version`
+
+	args := []interface{}{until, reason, user.ID, user.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var suspendedAt time.Time
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&suspendedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.SuspendedAt = &suspendedAt
+	user.SuspendedUntil = until
+	user.SuspensionReason = &reason
+	return nil
+}
+
+// SetLocale stores the language the user wants emails and API messages in. An empty
+// locale goes back to following the Accept-Language header.
+func (m UserModel) SetLocale(user *User, locale string) error {
+	query := `
+		UPDATE users
+		SET locale = $1, updated_at = NOW(), version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, locale, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.Locale = locale
+	return nil
+}
+
+// Unsuspend lifts any suspension on the account
+func (m UserModel) Unsuspend(user *User) error {
+	query := `
+		UPDATE users
+		SET suspended_at = NULL, suspended_until = NULL, suspension_reason = NULL,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.SuspendedAt = nil
+	user.SuspendedUntil = nil
+	user.SuspensionReason = nil
+	return nil
+}
+
 // Delete User
 func (m UserModel) Delete(id int64) error {
 	if id <= 0 {
 		return ErrRecordNotFound
 	}
-	query := `DELETE FROM users WHERE id=$1`
+	// The user's photos cascade with them, so queue their images to be destroyed on
+	// Cloudinary in the same statement.
+	query := `
+		WITH queued AS (
+			INSERT INTO asset_deletions (public_id)
+			SELECT public_id FROM user_photos WHERE user_id = $1 AND public_id <> ''
+		)
+		DELETE FROM users WHERE id=$1`
 	result, err := m.DB.Exec(query, id)
 	if err != nil {
 		return err
@@ -590,21 +707,24 @@ func (p *password) Match(plaintextPassword string) (bool, error) {
 
 // validation checks
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(email != "", "email", "validation.required")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "validation.email")
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", " must be at most 72 bytes long")
+	v.Check(password != "", "password", "validation.required")
+	v.Check(len(password) >= 8, "password", "validation.min_bytes", "n", "8")
+	v.Check(len(password) <= 72, "password", "validation.at_most_bytes", "n", "72")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.FirstName != "", "firstname", "must be provided")
-	v.Check(len(user.FirstName) <= 500, "firstname", "must not be more than 500 bytes long")
-	v.Check(user.LastName != "", "lastname", "must be provided")
-	v.Check(len(user.LastName) <= 500, "lastname", "must not be more than 500 bytes long")
+	v.Check(user.FirstName != "", "firstname", "validation.required")
+	v.Check(validator.MaxRunes(user.FirstName, 500), "firstname", "validation.max_chars", "n", "500")
+	v.Check(user.LastName != "", "lastname", "validation.required")
+	v.Check(validator.MaxRunes(user.LastName, 500), "lastname", "validation.max_chars", "n", "500")
+	if user.AboutYourself != nil {
+		v.Check(validator.MaxRunes(*user.AboutYourself, 2000), "about_yourself", "validation.max_chars", "n", "2000")
+	}
 
 	// Validate email
 	ValidateEmail(v, user.Email)
@@ -619,12 +739,16 @@ func ValidateUser(v *validator.Validator, user *User) {
 		panic("missing password hash for user")
 	}
 
+	if user.Address != nil {
+		ValidateAddress(v.Field("address"), user.Address)
+	}
+
 	// Student-specific validations
 	if user.Role == "student" {
 		//v.Check(user.Student != nil, "student", "Student details are required")
 		if user.Student != nil {
-			v.Check(user.Student.IvwID != "", "student_id", "must be provided")
-			v.Check(user.Student.FamilyBackground != nil, "family background", "must be provided")
+			v.Check(user.Student.IvwID != "", "student_id", "validation.required")
+			v.Check(user.Student.FamilyBackground != nil, "family background", "validation.required")
 			ValidateEducationLevel(v, user.Student.EducationLevel)
 		}
 	}
@@ -634,9 +758,9 @@ func ValidateUser(v *validator.Validator, user *User) {
 		age := calculateAge(*user.DateOfBirth)
 		if age < 18 {
 			// Guardian details are required for users under 18
-			v.Check(user.Guardian != nil, "guardian", "Guardian details are required for users under 18")
+			v.Check(user.Guardian != nil, "guardian", "validation.guardian_required")
 			if user.Guardian != nil {
-				ValidateGuardian(v, user.Guardian)
+				ValidateGuardian(v.Field("guardian"), user.Guardian)
 			}
 		}
 	}
@@ -645,36 +769,38 @@ func ValidateUser(v *validator.Validator, user *User) {
 	if user.Role == "tutor" && user.DateOfBirth != nil {
 		age := calculateAge(*user.DateOfBirth)
 		if age < 18 {
-			v.Check(false, "age", "Tutors must be at least 18 years old")
+			v.Check(false, "age", "validation.tutor_age")
 		}
 	}
 
 }
 
-// Guardian validation logic
+// Guardian validation logic. Pass v.Field("guardian") so the keys are reported as
+// guardian.first_name and so on.
 func ValidateGuardian(v *validator.Validator, guardian *Guardian) {
-	v.Check(guardian.FirstName != "", "guardian.first_name", "Guardian first name is required")
-	v.Check(len(guardian.FirstName) <= 500, "guardian.first_name", "Guardian first name is required")
-	v.Check(len(guardian.LastName) <= 500, "guardian.last_name", "Guardian last name is required")
-	v.Check(guardian.LastName != "", "guardian.last_name", "Guardian last name is required")
-	v.Check(guardian.RelationshipToStudent != "", "guardian.relationship_to_student", "Guardian relationship to student is required")
-	v.Check(guardian.Phone != "", "guardian.phone", "Guardian phone is required")
+	v.Check(guardian.FirstName != "", "first_name", "validation.guardian_first_name")
+	v.Check(validator.MaxRunes(guardian.FirstName, 500), "first_name", "validation.max_chars", "n", "500")
+	v.Check(guardian.LastName != "", "last_name", "validation.guardian_last_name")
+	v.Check(validator.MaxRunes(guardian.LastName, 500), "last_name", "validation.max_chars", "n", "500")
+	v.Check(guardian.RelationshipToStudent != "", "relationship_to_student", "validation.guardian_relationship")
+	v.Check(guardian.Phone != "", "phone", "validation.guardian_phone")
+	v.Check(validator.PhoneE164(guardian.Phone), "phone", "validation.phone", "example", "+2348012345678")
 	ValidateEmail(v, guardian.Email)
 }
 
 func ValidateEducationLevel(v *validator.Validator, educationLevel string) {
 	validEducationLevels := []string{"primary", "secondary", "tertiary", "other"}
-	v.Check(educationLevel != "", "education_level", "must be provided")
-	v.Check(validator.In(educationLevel, validEducationLevels...), "education_level", "must be one of preschool, primary, secondary, tertiary, or other")
+	v.Check(educationLevel != "", "education_level", "validation.required")
+	v.Check(validator.In(educationLevel, validEducationLevels...), "education_level", "validation.one_of", "values", "preschool, primary, secondary, tertiary, or other")
 }
 
 // ValidateImage checks the image file for size and type
 func ValidateImage(v *validator.Validator, filename string, fileHeader *multipart.FileHeader) {
 	// Check if file is provided
-	v.Check(fileHeader.Size > 0, "file", "file must be provided")
+	v.Check(fileHeader.Size > 0, "file", "validation.file_required")
 
 	// Check file size
-	v.Check(fileHeader.Size < 1024*1024*5, "file", "file size should be less than 5MB")
+	v.Check(fileHeader.Size < 1024*1024*5, "file", "validation.file_size", "size", "5MB")
 
 	// Check file type by suffix
 	validExtensions := []string{".png", ".jpg", ".jpeg", ".gif"}
@@ -685,7 +811,15 @@ func ValidateImage(v *validator.Validator, filename string, fileHeader *multipar
 			break
 		}
 	}
-	v.Check(validType, "file", "file type should be PNG, JPG, JPEG, or GIF")
+	v.Check(validType, "file", "validation.file_type")
+}
+
+func ValidateSuspension(v *validator.Validator, reason string, until *time.Time) {
+	v.Check(reason != "", "reason", "validation.required")
+	v.Check(validator.MaxRunes(reason, 1000), "reason", "validation.max_chars", "n", "1000")
+	if until != nil {
+		v.Check(until.After(time.Now()), "until", "validation.future")
+	}
 }
 
 func calculateAge(dob time.Time) int {
@@ -704,6 +838,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		SELECT u.id, u.email, u.password, u.first_name, u.last_name, u.username,
 			u.activated, u.role, u.about_yourself, u.date_of_birth, u.gender,
 			u.created_at, u.updated_at, u.version,
+			u.suspended_at, u.suspended_until, u.suspension_reason, u.locale,
 			up.photo_url, up.public_id, up.created_at AS photo_created_at, up.updated_at AS photo_updated_at,
 			a.id AS address_id, a.street_address_1, a.street_address_2, a.city, a.state, a.zipcode, a.country,
 			s.id AS student_id, s.ivw_id, s.family_background,
@@ -742,6 +877,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.ID, &user.Email, &user.Password.hash, &user.FirstName, &user.LastName, &user.Username,
 		&user.Activated, &user.Role, &user.AboutYourself, &user.DateOfBirth, &user.Gender,
 		&user.CreatedAt, &user.UpdatedAt, &user.Version,
+		&user.SuspendedAt, &user.SuspendedUntil, &user.SuspensionReason, &user.Locale,
 		&photoURL, &photoPublicID, &photoCreatedAt, &photoUpdatedAt,
 		&address.ID, &address.StreetAddress1, &address.StreetAddress2, &address.City, &address.State, &address.Zipcode, &address.Country,
 		&studentID, &ivwID, &familyBackground,
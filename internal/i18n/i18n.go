@@ -0,0 +1,160 @@
+// Package i18n holds the message catalogues the API answers in. Messages are keyed by
+// a code, such as error.not_found, and each locale has a catalogue in locales/. English
+// is the reference catalogue: any message missing from another locale falls back to it.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale used when a client doesn't ask for one we support
+const Default = "en"
+
+//go:embed "locales"
+var localeFS embed.FS
+
+// catalogues maps each supported locale to its messages, keyed by code
+var catalogues = map[string]map[string]string{}
+
+// placeholderRX matches the {name} placeholders in a message
+var placeholderRX = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// pattern recognises a message that was written out in English, so it can be
+// translated after the fact
+type pattern struct {
+	code   string
+	rx     *regexp.Regexp
+	names  []string
+	weight int
+}
+
+// exact maps the lower-cased English text of messages without placeholders to their
+// code, and patterns holds the ones with placeholders, most specific first
+var (
+	exact    = map[string]string{}
+	patterns []pattern
+)
+
+func init() {
+	files, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		b, err := localeFS.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(b, &messages); err != nil {
+			panic("i18n: " + f.Name() + ": " + err.Error())
+		}
+		catalogues[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+
+	for code, text := range catalogues[Default] {
+		if !placeholderRX.MatchString(text) {
+			exact[strings.ToLower(text)] = code
+			continue
+		}
+		p := pattern{code: code, weight: len(placeholderRX.ReplaceAllString(text, ""))}
+		// a message that is nothing but a placeholder would match anything
+		if p.weight == 0 {
+			continue
+		}
+		expr := ""
+		last := 0
+		for _, m := range placeholderRX.FindAllStringSubmatchIndex(text, -1) {
+			expr += regexp.QuoteMeta(text[last:m[0]]) + "(.+?)"
+			p.names = append(p.names, text[m[2]:m[3]])
+			last = m[1]
+		}
+		expr += regexp.QuoteMeta(text[last:])
+		p.rx = regexp.MustCompile("(?i)^" + expr + "$")
+		patterns = append(patterns, p)
+	}
+	// Try the patterns with the most fixed text first, so "must not be more than
+	// {n} characters long" wins over "must not be more than {max}".
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].weight != patterns[j].weight {
+			return patterns[i].weight > patterns[j].weight
+		}
+		return patterns[i].code < patterns[j].code
+	})
+}
+
+// Supported returns true if there is a catalogue for locale
+func Supported(locale string) bool {
+	_, ok := catalogues[locale]
+	return ok
+}
+
+// Negotiate picks the supported locale the client prefers from an Accept-Language
+// header, such as "fr-CA,fr;q=0.9,en;q=0.8". Regional variants are matched on their
+// language, and Default is returned when nothing in the header is supported.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q > bestQ && Supported(lang) {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// T returns the message for code in locale, with its placeholders filled in from args,
+// which are given as name, value pairs. Messages missing from locale are taken from the
+// English catalogue, and unknown codes are returned as they are.
+func T(locale, code string, args ...string) string {
+	text, ok := catalogues[locale][code]
+	if !ok {
+		text, ok = catalogues[Default][code]
+		if !ok {
+			return code
+		}
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		text = strings.ReplaceAll(text, "{"+args[i]+"}", args[i+1])
+	}
+	return text
+}
+
+// Localize translates a message that was written out in English, such as an error from
+// decoding a request body, by matching it against the English catalogue. Messages that aren't in the
+// catalogue are returned unchanged.
+func Localize(locale, message string) string {
+	if locale == Default || !Supported(locale) {
+		return message
+	}
+	if code, ok := exact[strings.ToLower(message)]; ok {
+		return T(locale, code)
+	}
+	for _, p := range patterns {
+		m := p.rx.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		args := make([]string, 0, len(p.names)*2)
+		for i, name := range p.names {
+			args = append(args, name, m[i+1])
+		}
+		return T(locale, p.code, args...)
+	}
+	return message
+}
@@ -0,0 +1,130 @@
+package i18n
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestValidationCodesAreCatalogued fails when a code passed to Check or AddError has no
+// entry in the English catalogue, or is given arguments that don't match its placeholders
+func TestValidationCodesAreCatalogued(t *testing.T) {
+	fset := token.NewFileSet()
+
+	for _, root := range []string{"../../cmd", "../../internal"} {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				return err
+			}
+
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				return err
+			}
+
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || call.Ellipsis.IsValid() {
+					return true
+				}
+
+				// the code follows the key, which Check takes after the condition
+				var args []ast.Expr
+				switch {
+				case sel.Sel.Name == "Check" && len(call.Args) >= 3:
+					args = call.Args[2:]
+				case sel.Sel.Name == "AddError" && len(call.Args) >= 2:
+					args = call.Args[1:]
+				default:
+					return true
+				}
+
+				pos := fset.Position(args[0].Pos())
+				code, ok := stringLit(args[0])
+				if !ok {
+					t.Errorf("%s: message code is not a string literal", pos)
+					return true
+				}
+				text, ok := catalogues[Default][code]
+				if !ok {
+					t.Errorf("%s: %q has no entry in locales/en.json", pos, code)
+					return true
+				}
+
+				want := placeholderRX.FindAllStringSubmatch(text, -1)
+				var got []string
+				for i := 1; i < len(args); i += 2 {
+					name, ok := stringLit(args[i])
+					if !ok {
+						t.Errorf("%s: placeholder name is not a string literal", pos)
+						return true
+					}
+					got = append(got, name)
+				}
+				if len(args[1:])%2 != 0 || len(got) != len(want) {
+					t.Errorf("%s: %q takes %d arguments, got %d", pos, code, len(want)*2, len(args[1:]))
+					return true
+				}
+				for _, m := range want {
+					if !contains(got, m[1]) {
+						t.Errorf("%s: %q is missing the {%s} argument", pos, code, m[1])
+					}
+				}
+				return true
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TestCataloguesMatchEnglish fails when a locale has a code the English catalogue
+// doesn't, or a message whose placeholders differ from the English one
+func TestCataloguesMatchEnglish(t *testing.T) {
+	for locale, messages := range catalogues {
+		for code, text := range messages {
+			english, ok := catalogues[Default][code]
+			if !ok {
+				t.Errorf("%s: %s is not in the English catalogue", locale, code)
+				continue
+			}
+			want := placeholderRX.FindAllString(english, -1)
+			got := placeholderRX.FindAllString(text, -1)
+			sort.Strings(want)
+			sort.Strings(got)
+			if strings.Join(want, " ") != strings.Join(got, " ") {
+				t.Errorf("%s: %s has placeholders %v, want %v", locale, code, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package jsonlog
+
+import "time"
+
+// Field is a typed property of a log entry. Numbers and booleans keep their JSON types
+// instead of being written as strings.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration is written in milliseconds, under the key with "_ms" appended
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key + "_ms", Value: float64(value.Microseconds()) / 1000}
+}
+
+func Time(key string, value time.Time) Field {
+	return Field{Key: key, Value: value.UTC().Format(time.RFC3339)}
+}
+
+// Err is written under the "error" key. A nil error is written as null.
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
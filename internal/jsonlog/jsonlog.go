@@ -2,10 +2,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,7 +18,9 @@ type Level int8
 // initialize a constant which represents a specific severity level.
 // use iota keyword to assign successive integer values to the constants
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -24,69 +29,140 @@ const (
 // return a human-friendly string representation for the severity level
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "Debug"
 	case LevelInfo:
 		return "Info"
+	case LevelWarn:
+		return "Warn"
 	case LevelError:
 		return "Error"
 	case LevelFatal:
 		return "Fatal"
+	case LevelOff:
+		return "Off"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel returns the level with the given name, ignoring case
+func ParseLevel(name string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelOff, fmt.Errorf("unknown log level %q", name)
+}
+
 // Define a custom Logger type. This holds the output destination that the log entries
 // will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
+// plus a mutex for coordinating the writes. Child loggers made with With() and Sampled()
+// share all of these with their parent, and add their own bound fields and sampling.
 type Logger struct {
+	core    *core
+	fields  []Field
+	sampler *sampler
+}
+
+type core struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
 }
 
 // Return a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination. Use Tee() to write to several at once.
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+	l := &Logger{core: &core{out: out}}
+	l.SetLevel(minLevel)
+	return l
+}
+
+// SetLevel changes the minimum severity level while the application is running. It
+// applies to the logger, its parent and all of their children.
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+// Level returns the current minimum severity level
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
+}
+
+// With returns a child logger that adds the fields to every entry it writes
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.fields = append(append([]Field{}, l.fields...), fields...)
+	return &child
+}
+
+// Sampled returns a child logger for hot paths. Each second, it writes the first entries
+// with a given message and then only every thereafter'th one. Entries at warn level and
+// above are never dropped.
+func (l *Logger) Sampled(first, thereafter int) *Logger {
+	child := *l
+	child.sampler = newSampler(first, thereafter)
+	return &child
 }
 
 // helper func for writing log entries at different severity levels
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, properties, nil)
 }
 
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), properties, nil)
 }
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), properties, nil)
 	os.Exit(1) //log entries at fatal level, terminate the application
 
 }
 
+// Debug, Info, Warn and Error write entries with typed fields
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.print(LevelDebug, message, nil, fields)
+}
+
+func (l *Logger) Info(message string, fields ...Field) {
+	l.print(LevelInfo, message, nil, fields)
+}
+
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.print(LevelWarn, message, nil, fields)
+}
+
+func (l *Logger) Error(message string, fields ...Field) {
+	l.print(LevelError, message, nil, fields)
+}
+
 // print is an internal method
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, properties map[string]string, fields []Field) (int, error) {
 	// if the severity level of the log entry is less that the minimum severity
 	// level of the logger then return with no further action
-	if level < l.minLevel {
+	if level < l.Level() {
+		return 0, nil
+	}
+
+	// hot paths only write a sample of their less important entries
+	if l.sampler != nil && level < LevelWarn && !l.sampler.allow(message) {
 		return 0, nil
 	}
 
 	//declare an annonymous struct to hold the data for the log entry
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
 		Message:    message,
-		Properties: properties,
+		Properties: mergeProperties(l.fields, fields, properties),
 	}
 
 	//inclued a  stack trace for entries at error and fatal levels
@@ -109,16 +185,36 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	// Lock the mutex so that no two writes to the output destination can happen
 	// concurrently. If we don't do this, it's possible that the text for two or more
 	// log entries will be intermingled in the output.
-	l.mu.Lock()
+	l.core.mu.Lock()
 
-	defer l.mu.Unlock()
+	defer l.core.mu.Unlock()
 
 	//write log entry followed by a new line
-	return l.out.Write(append(line, '\n'))
+	return l.core.out.Write(append(line, '\n'))
+}
+
+// mergeProperties combines the bound fields, the fields of the entry and the untyped
+// properties, later ones winning when keys clash
+func mergeProperties(bound, fields []Field, properties map[string]string) map[string]interface{} {
+	if len(bound)+len(fields)+len(properties) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]interface{}, len(bound)+len(fields)+len(properties))
+	for _, f := range bound {
+		merged[f.Key] = f.Value
+	}
+	for _, f := range fields {
+		merged[f.Key] = f.Value
+	}
+	for key, value := range properties {
+		merged[key] = value
+	}
+	return merged
 }
 
 // implement a Write() method on our Logger type that satisfies the io.Writer interface
 // This will write log entry at the Error Level with no additional properties
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.print(LevelError, string(message), nil, nil)
 }
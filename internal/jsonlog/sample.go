@@ -0,0 +1,44 @@
+package jsonlog
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler counts the entries written with each message during the current second
+type sampler struct {
+	first      int
+	thereafter int
+
+	mu     sync.Mutex
+	second time.Time
+	counts map[string]int
+}
+
+func newSampler(first, thereafter int) *sampler {
+	return &sampler{
+		first:      first,
+		thereafter: thereafter,
+		counts:     make(map[string]int),
+	}
+}
+
+// allow reports whether an entry with the message should be written
+func (s *sampler) allow(message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Truncate(time.Second)
+	if !now.Equal(s.second) {
+		s.second = now
+		s.counts = make(map[string]int)
+	}
+
+	s.counts[message]++
+	n := s.counts[message]
+
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
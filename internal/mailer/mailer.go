@@ -15,8 +15,11 @@ import (
 var templateFS embed.FS
 
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	dialer          *mail.Dialer
+	sender          string
+	onSend          func(templateFile string, err error)
+	onOverrideError func(templateFile, locale string, err error)
+	load            TemplateLoader
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -31,36 +34,45 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Parse the template file from the embedded file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// Ping connects and authenticates to the SMTP server without sending anything, to check
+// that the mailer is configured correctly
+func (m Mailer) Ping() error {
+	s, err := m.dialer.Dial()
 	if err != nil {
-		return fmt.Errorf("error parsing template %s: %w", templateFile, err)
+		return err
 	}
+	return s.Close()
+}
 
-	// Execute templates for subject, plain text body, and HTML body.
-	subject, err := executeTemplate(tmpl, "subject", data)
-	if err != nil {
-		return fmt.Errorf("error executing subject template: %w", err)
-	}
+// OnSend registers fn to be called with the result of every email sent, for metrics
+func (m *Mailer) OnSend(fn func(templateFile string, err error)) {
+	m.onSend = fn
+}
 
-	plainBody, err := executeTemplate(tmpl, "plainBody", data)
-	if err != nil {
-		return fmt.Errorf("error executing plainBody template: %w", err)
+// Send renders templateFile in the recipient's locale and sends it. See Template for
+// how the template is chosen.
+func (m Mailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	err := m.send(recipient, locale, templateFile, data)
+	if m.onSend != nil {
+		m.onSend(templateFile, err)
 	}
+	return err
+}
 
-	htmlBody, err := executeTemplate(tmpl, "htmlBody", data)
+func (m Mailer) send(recipient, locale, templateFile string, data interface{}) error {
+	// Execute templates for subject, plain text body, and HTML body.
+	message, err := m.render(templateFile, locale, data)
 	if err != nil {
-		return fmt.Errorf("error executing htmlBody template: %w", err)
+		return err
 	}
 
 	// Create a new message and set headers and body.
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject)
-	msg.SetBody("text/plain", plainBody)
-	msg.AddAlternative("text/html", htmlBody)
+	msg.SetHeader("Subject", message.Subject)
+	msg.SetBody("text/plain", message.PlainBody)
+	msg.AddAlternative("text/html", message.HTMLBody)
 
 	// Attempt to send the email up to three times.
 	for i := 0; i < 3; i++ {
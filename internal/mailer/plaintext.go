@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	headRX       = regexp.MustCompile(`(?is)<head\b.*?</head>`)
+	linkRX       = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	lineBreakRX  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	blockRX      = regexp.MustCompile(`(?i)</?(p|h[1-6]|div|li|tr|table)\b[^>]*>`)
+	tagRX        = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespaceRX = regexp.MustCompile(`\s+`)
+	blankLinesRX = regexp.MustCompile(`\n{3,}`)
+)
+
+// plainText turns the HTML body of an email into the plain text alternative, for
+// templates that don't define a plainBody. Paragraphs and headings become blank-line
+// separated blocks, and links are written out with their address.
+func plainText(htmlBody string) string {
+	s := headRX.ReplaceAllString(htmlBody, "")
+	// whitespace in the source is only layout, the tags below decide where lines break
+	s = whitespaceRX.ReplaceAllString(s, " ")
+
+	s = linkRX.ReplaceAllStringFunc(s, func(link string) string {
+		m := linkRX.FindStringSubmatch(link)
+		href := strings.TrimPrefix(m[1], "mailto:")
+		text := strings.TrimSpace(tagRX.ReplaceAllString(m[2], ""))
+		if text == "" || text == href {
+			return href
+		}
+		return text + " (" + href + ")"
+	})
+	s = lineBreakRX.ReplaceAllString(s, "\n")
+	s = blockRX.ReplaceAllString(s, "\n\n")
+	s = tagRX.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	s = strings.Join(lines, "\n")
+	s = blankLinesRX.ReplaceAllString(s, "\n\n")
+
+	return strings.TrimSpace(s) + "\n"
+}
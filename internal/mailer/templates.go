@@ -0,0 +1,309 @@
+package mailer
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// The templates the application sends
+const (
+	AccountErasureTemplate     = "account_erasure.tmpl"
+	AccountLockedTemplate      = "account_locked.tmpl"
+	DataExportTemplate         = "data_export.tmpl"
+	EmailChangeConfirmTemplate = "email_change_confirm.tmpl"
+	EmailChangeNoticeTemplate  = "email_change_notice.tmpl"
+	MagicLinkTemplate          = "magic_link.tmpl"
+	PasswordResetTemplate      = "password_reset.tmpl"
+	StudentWelcomeTemplate     = "student_welcome.tmpl"
+	TutorVerifiedTemplate      = "tutor_verified.tmpl"
+	TutorWelcomeTemplate       = "tutor_welcome.tmpl"
+	VerifyEmailTemplate        = "verify_email.tmpl"
+)
+
+// sent lists every template the application sends, for CheckTemplates
+var sent = []string{
+	AccountErasureTemplate,
+	AccountLockedTemplate,
+	DataExportTemplate,
+	EmailChangeConfirmTemplate,
+	EmailChangeNoticeTemplate,
+	MagicLinkTemplate,
+	PasswordResetTemplate,
+	StudentWelcomeTemplate,
+	TutorVerifiedTemplate,
+	TutorWelcomeTemplate,
+	VerifyEmailTemplate,
+}
+
+// TemplateLoader returns the source of a template that overrides the embedded
+// templateFile in locale, and false when it isn't overridden
+type TemplateLoader func(templateFile, locale string) (string, bool)
+
+// Message is a rendered email
+type Message struct {
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+}
+
+// LoadTemplatesFrom registers fn to be asked for overrides of the embedded templates, so
+// their wording can be changed without a redeploy
+func (m *Mailer) LoadTemplatesFrom(fn TemplateLoader) {
+	m.load = fn
+}
+
+// OnOverrideError registers fn to be called when an override can't be parsed or rendered
+// and the embedded template is sent in its place
+func (m *Mailer) OnOverrideError(fn func(templateFile, locale string, err error)) {
+	m.onOverrideError = fn
+}
+
+// Template returns the template templateFile is sent with in locale. An override in the
+// locale wins over the embedded translation in templates/<locale>/, and when there is
+// neither the English override or embedded template is used.
+func (m Mailer) Template(templateFile, locale string) (*template.Template, error) {
+	if source, overrideLocale, ok := m.findOverride(templateFile, locale); ok {
+		return Parse(templateFile, overrideLocale, source)
+	}
+	return embeddedTemplate(templateFile, locale)
+}
+
+// findOverride returns the source of the override Template would use, and the locale it
+// was saved in
+func (m Mailer) findOverride(templateFile, locale string) (string, string, bool) {
+	if locale != "" && locale != "en" {
+		if source, ok := m.override(templateFile, locale); ok {
+			return source, locale, true
+		}
+		if _, err := fs.Stat(templateFS, path.Join("templates", locale, templateFile)); err == nil {
+			return "", "", false
+		}
+	}
+	source, ok := m.override(templateFile, "en")
+	return source, "en", ok
+}
+
+// embeddedTemplate returns the embedded translation of templateFile in locale, or the
+// English template when there isn't one
+func embeddedTemplate(templateFile, locale string) (*template.Template, error) {
+	if locale != "" && locale != "en" {
+		file := path.Join("templates", locale, templateFile)
+		if _, err := fs.Stat(templateFS, file); err == nil {
+			return parseEmbedded(templateFile, locale, file)
+		}
+	}
+	return parseEmbedded(templateFile, "en", path.Join("templates", templateFile))
+}
+
+// render renders the email templateFile is sent as in locale. An override that no longer
+// parses or renders, say after a change to the layout, is reported and the embedded
+// template is sent instead, so a broken override can't stop the email.
+func (m Mailer) render(templateFile, locale string, data interface{}) (*Message, error) {
+	if source, overrideLocale, ok := m.findOverride(templateFile, locale); ok {
+		tmpl, err := Parse(templateFile, overrideLocale, source)
+		if err == nil {
+			var message *Message
+			message, err = Render(tmpl, data)
+			if err == nil {
+				return message, nil
+			}
+		}
+		if m.onOverrideError != nil {
+			m.onOverrideError(templateFile, overrideLocale, err)
+		}
+	}
+
+	tmpl, err := embeddedTemplate(templateFile, locale)
+	if err != nil {
+		return nil, err
+	}
+	return Render(tmpl, data)
+}
+
+// CheckTemplates renders every embedded template the application sends with sample data,
+// in English and in each translation, failing on any variable the sample data doesn't
+// have. It is run at startup, so a missing or broken template stops the server instead
+// of failing in the background when the email is sent. Overrides aren't checked: they
+// were checked when they were saved, and one broken since falls back to the embedded
+// template when it is sent.
+func (m Mailer) CheckTemplates() error {
+	locales := []string{"en"}
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != "layouts" && entry.Name() != "partials" {
+			locales = append(locales, entry.Name())
+		}
+	}
+
+	for _, templateFile := range sent {
+		for _, locale := range locales {
+			tmpl, err := embeddedTemplate(templateFile, locale)
+			if err == nil {
+				_, err = RenderStrict(tmpl, SampleData())
+			}
+			if err != nil {
+				return fmt.Errorf("email template %s in %s: %w", templateFile, locale, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m Mailer) override(templateFile, locale string) (string, bool) {
+	if m.load == nil {
+		return "", false
+	}
+	return m.load(templateFile, locale)
+}
+
+func parseEmbedded(templateFile, locale, file string) (*template.Template, error) {
+	source, err := fs.ReadFile(templateFS, file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template %s: %w", templateFile, err)
+	}
+	return Parse(templateFile, locale, string(source))
+}
+
+// layout parses the base layout and the partials for locale, which an email's own
+// templates are added to. Partials in templates/<locale>/partials/ replace the English
+// ones.
+func layout(locale string) (*template.Template, error) {
+	tmpl, err := template.New("email").Funcs(templateFuncs(locale)).
+		ParseFS(templateFS, "templates/layouts/*.tmpl", "templates/partials/*.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing email layout: %w", err)
+	}
+	if locale != "en" {
+		partials, err := fs.Glob(templateFS, path.Join("templates", locale, "partials", "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(partials) > 0 {
+			tmpl, err = tmpl.ParseFS(templateFS, partials...)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing email layout: %w", err)
+			}
+		}
+	}
+	return tmpl, nil
+}
+
+// templateFuncs are the functions templates can call
+func templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"locale": func() string { return locale },
+	}
+}
+
+// Templates returns the names of the embedded templates, which are the ones that can be
+// overridden
+func Templates() []string {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = path.Base(f)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasTemplate returns true if templateFile is one of the embedded templates
+func HasTemplate(templateFile string) bool {
+	for _, name := range Templates() {
+		if name == templateFile {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses the source of an email template in locale. It must define a subject, and
+// either the content that goes in the base layout or an htmlBody of its own. When it
+// doesn't define a plainBody, one is generated from the HTML.
+func Parse(templateFile, locale, source string) (*template.Template, error) {
+	// Look at what the source defines on its own, before the layout fills in htmlBody
+	own, err := template.New("email").Funcs(templateFuncs(locale)).Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", templateFile, err)
+	}
+	if own.Lookup("subject") == nil {
+		return nil, fmt.Errorf("template %s must define subject", templateFile)
+	}
+	if own.Lookup("content") == nil && own.Lookup("htmlBody") == nil {
+		return nil, fmt.Errorf("template %s must define content or htmlBody", templateFile)
+	}
+
+	tmpl, err := layout(locale)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err = tmpl.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", templateFile, err)
+	}
+	return tmpl, nil
+}
+
+// Render executes the subject, plainBody and htmlBody blocks of tmpl with data
+func Render(tmpl *template.Template, data interface{}) (*Message, error) {
+	subject, err := executeTemplate(tmpl, "subject", data)
+	if err != nil {
+		return nil, fmt.Errorf("error executing subject template: %w", err)
+	}
+
+	htmlBody, err := executeTemplate(tmpl, "htmlBody", data)
+	if err != nil {
+		return nil, fmt.Errorf("error executing htmlBody template: %w", err)
+	}
+
+	plainBody := plainText(htmlBody)
+	if tmpl.Lookup("plainBody") != nil {
+		plainBody, err = executeTemplate(tmpl, "plainBody", data)
+		if err != nil {
+			return nil, fmt.Errorf("error executing plainBody template: %w", err)
+		}
+	}
+
+	return &Message{Subject: subject, PlainBody: plainBody, HTMLBody: htmlBody}, nil
+}
+
+// RenderStrict is Render, except that a variable data doesn't have is an error rather
+// than being left blank. Templates are checked and previewed with it, so a misspelt
+// variable is caught before the email is sent.
+func RenderStrict(tmpl *template.Template, data interface{}) (*Message, error) {
+	strict, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return Render(strict.Option("missingkey=error"), data)
+}
+
+// SampleData returns placeholder values for everything the templates are rendered with,
+// for previews
+func SampleData() map[string]interface{} {
+	return map[string]interface{}{
+		"firstName":       "Ada",
+		"tutorName":       "Ada Lovelace",
+		"newEmail":        "ada.lovelace@example.com",
+		"activationToken": "SAMPLEACTIVATIONTOKEN",
+		"resetToken":      "SAMPLERESETTOKEN",
+		"magicLinkToken":  "SAMPLEMAGICLINKTOKEN",
+		"confirmToken":    "SAMPLECONFIRMTOKEN",
+		"revertToken":     "SAMPLEREVERTTOKEN",
+		"downloadToken":   "SAMPLEDOWNLOADTOKEN",
+		"expiresIn":       "48 hours",
+		"scheduledFor":    "1 January 2030",
+		"lockedUntil":     "1 January 2030 12:00 UTC",
+		"ip":              "203.0.113.10",
+		"logoURL":         "https://res.cloudinary.com/dbm6gjv59/image/upload/v1721847638/Group_1_i6y4u4.png",
+	}
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckTemplates(t *testing.T) {
+	if err := (Mailer{}).CheckTemplates(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderStrictMissingVariable(t *testing.T) {
+	source := `{{define "subject"}}Hello {{.frstName}}{{end}}{{define "content"}}<p>Hi</p>{{end}}`
+
+	tmpl, err := Parse(VerifyEmailTemplate, "en", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RenderStrict(tmpl, SampleData())
+	if err == nil || !strings.Contains(err.Error(), "frstName") {
+		t.Errorf("RenderStrict() error = %v, want one naming frstName", err)
+	}
+
+	// Sending keeps the default, so one missing value doesn't stop the email
+	if _, err := Render(tmpl, SampleData()); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+}
+
+func TestBrokenOverrideFallsBack(t *testing.T) {
+	var m Mailer
+	m.LoadTemplatesFrom(func(templateFile, locale string) (string, bool) {
+		return `{{define "subject"}}{{template "missing"}}{{end}}{{define "content"}}{{end}}`, true
+	})
+	var reported error
+	m.OnOverrideError(func(templateFile, locale string, err error) {
+		reported = err
+	})
+
+	if err := m.CheckTemplates(); err != nil {
+		t.Fatalf("CheckTemplates() error = %v, want overrides to be skipped", err)
+	}
+
+	message, err := m.render(VerifyEmailTemplate, "en", SampleData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reported == nil {
+		t.Error("the broken override wasn't reported")
+	}
+
+	tmpl, err := embeddedTemplate(VerifyEmailTemplate, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Render(tmpl, SampleData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != want.Subject {
+		t.Errorf("subject = %q, want the embedded %q", message.Subject, want.Subject)
+	}
+}
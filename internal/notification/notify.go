@@ -2,6 +2,7 @@ package notification
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 // notificationService handles Pusher notifications.
 type NotificationService struct {
     pusherClient *pusher.Client
+    onSend       func(event string, err error)
 }
 
 // New creates a new notificationService instance.
@@ -20,8 +22,30 @@ func New(pusherClient *pusher.Client) *NotificationService {
     }
 }
 
+// Configured returns an error if any of the Pusher credentials are missing. Pusher has
+// no way to check them without sending a notification.
+func (ns *NotificationService) Configured() error {
+	if ns.pusherClient == nil || ns.pusherClient.AppID == "" || ns.pusherClient.Key == "" || ns.pusherClient.Secret == "" || ns.pusherClient.Cluster == "" {
+		return errors.New("pusher credentials are not configured")
+	}
+	return nil
+}
+
+// OnSend registers fn to be called with the result of every notification sent, for metrics.
+func (ns *NotificationService) OnSend(fn func(event string, err error)) {
+	ns.onSend = fn
+}
+
 // SendNotification sends a notification with the specified channel, event, and message.
 func (ns *NotificationService) SendNotification(channel, event string, message map[string]interface{}) error {
+	err := ns.send(channel, event, message)
+	if ns.onSend != nil {
+		ns.onSend(event, err)
+	}
+	return err
+}
+
+func (ns *NotificationService) send(channel, event string, message map[string]interface{}) error {
 	messageJSON, err := json.Marshal(message)
 	if err != nil {
 		return err
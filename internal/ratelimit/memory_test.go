@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store whose clock only moves when the returned function is called
+func newTestStore() (*MemoryStore, func(time.Duration)) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func take(t *testing.T, s *MemoryStore, key string, limit Limit) Result {
+	t.Helper()
+	result, err := s.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	s, _ := newTestStore()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for want := 2; want >= 0; want-- {
+		result := take(t, s, "ip:1", limit)
+		if !result.Allowed {
+			t.Fatalf("request %d was refused", 3-want)
+		}
+		if result.Remaining != want {
+			t.Errorf("Remaining = %d, want %d", result.Remaining, want)
+		}
+		if result.RetryAfter != 0 {
+			t.Errorf("RetryAfter = %v for an allowed request", result.RetryAfter)
+		}
+	}
+
+	result := take(t, s, "ip:1", limit)
+	if result.Allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if result.Limit != 3 {
+		t.Errorf("Limit = %d, want 3", result.Limit)
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", result.RetryAfter)
+	}
+	if result.Reset != 3*time.Second {
+		t.Errorf("Reset = %v, want 3s", result.Reset)
+	}
+
+	// a refused request doesn't spend a token
+	if again := take(t, s, "ip:1", limit); again.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v after a second refusal, want 1s", again.RetryAfter)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	s, advance := newTestStore()
+	limit := Limit{Rate: 2, Burst: 2}
+
+	take(t, s, "ip:1", limit)
+	take(t, s, "ip:1", limit)
+	if take(t, s, "ip:1", limit).Allowed {
+		t.Fatal("empty bucket allowed a request")
+	}
+
+	advance(250 * time.Millisecond)
+	result := take(t, s, "ip:1", limit)
+	if result.Allowed {
+		t.Fatal("half a token allowed a request")
+	}
+	if result.RetryAfter != 250*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 250ms", result.RetryAfter)
+	}
+
+	advance(250 * time.Millisecond)
+	if !take(t, s, "ip:1", limit).Allowed {
+		t.Fatal("refilled token was refused")
+	}
+
+	// the bucket never holds more than its burst, however long it is left
+	advance(time.Hour)
+	result = take(t, s, "ip:1", limit)
+	if result.Remaining != 1 {
+		t.Errorf("Remaining = %d after a long wait, want 1", result.Remaining)
+	}
+	if result.Reset != 500*time.Millisecond {
+		t.Errorf("Reset = %v, want 500ms", result.Reset)
+	}
+}
+
+func TestMemoryStoreKeysAreSeparate(t *testing.T) {
+	s, _ := newTestStore()
+	limit := Limit{Rate: 1, Burst: 1}
+
+	take(t, s, "ip:1", limit)
+	if take(t, s, "ip:1", limit).Allowed {
+		t.Fatal("second request for ip:1 was allowed")
+	}
+	if !take(t, s, "ip:2", limit).Allowed {
+		t.Error("ip:2 was limited by ip:1's requests")
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	s, advance := newTestStore()
+	limit := Limit{Rate: 1, Burst: 1}
+
+	take(t, s, "idle", limit)
+	advance(idleTimeout)
+	take(t, s, "recent", limit)
+	advance(time.Minute)
+
+	if err := s.Cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := s.buckets["idle"]; found {
+		t.Error("idle bucket was kept")
+	}
+	if _, found := s.buckets["recent"]; !found {
+		t.Error("recent bucket was removed")
+	}
+}
+
+func TestPer(t *testing.T) {
+	limit := Per(30, time.Minute)
+	if limit.Burst != 30 || limit.Rate != 0.5 {
+		t.Errorf("Per(30, time.Minute) = %+v, want a burst of 30 at 0.5/s", limit)
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so every instance of the
+// application shares the same limits
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// refilled is the number of tokens in an existing bucket once it has been topped up for
+// the time since it was last used. $2 is the rate and $3 the burst.
+const refilled = `LEAST($3::double precision, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at)::double precision * $2::double precision)`
+
+// Take updates the bucket in a single statement, so concurrent requests from different
+// instances can't both spend the last token
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	query := `
+		INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at)
+		VALUES ($1, $3::double precision - 1, true, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+			allowed = ` + refilled + ` >= 1,
+			updated_at = NOW()
+		RETURNING tokens, allowed`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+
+	err := s.DB.QueryRowContext(ctx, query, key, limit.Rate, float64(limit.Burst)).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(limit, tokens, allowed), nil
+}
+
+func (s *PostgresStore) Cleanup(ctx context.Context) error {
+	query := `
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, time.Now().Add(-idleTimeout))
+	return err
+}
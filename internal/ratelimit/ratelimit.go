@@ -0,0 +1,73 @@
+// Package ratelimit implements token bucket rate limiting with pluggable storage, so
+// limits can be kept per process in memory or shared between instances in PostgreSQL.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket. Up to Burst requests can be made at once, after which
+// the bucket refills at Rate requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Per returns a limit of n requests per period, all of which can be made at once
+func Per(n int, period time.Duration) Limit {
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is how many requests can still be made straight away
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request will be allowed. It is zero when
+	// the request was allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. Take removes a token from the bucket for the key if there is
+// one, creating a full bucket for keys it hasn't seen. Cleanup forgets buckets that have
+// been idle long enough to be full again.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	Cleanup(ctx context.Context) error
+}
+
+// idleTimeout is how long a bucket is kept after its last request. It is long enough for
+// the buckets of every limit in use to have refilled, so forgetting them changes nothing.
+const idleTimeout = time.Hour
+
+// refill returns the tokens in a bucket that held tokens the given time ago
+func refill(limit Limit, tokens float64, elapsed time.Duration) float64 {
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// newResult describes a bucket left holding the given tokens
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 || math.IsInf(s, 0) || math.IsNaN(s) {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
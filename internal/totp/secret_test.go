@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Seal(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == secret {
+		t.Fatal("sealed secret is the plaintext")
+	}
+
+	opened, err := Open(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != secret {
+		t.Errorf("got %q; want %q", opened, secret)
+	}
+
+	_, err = Open(bytes.Repeat([]byte{8}, KeySize), sealed)
+	if err == nil {
+		t.Error("opened with the wrong key")
+	}
+
+	_, err = Open(nil, sealed)
+	if !errors.Is(err, ErrNoKey) {
+		t.Errorf("got %v; want ErrNoKey", err)
+	}
+}
+
+func TestOpenPlaintext(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	for _, k := range [][]byte{nil, key} {
+		opened, err := Open(k, "JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != "JBSWY3DPEHPK3PXP" {
+			t.Errorf("got %q", opened)
+		}
+	}
+
+	sealed, err := Seal(nil, "JBSWY3DPEHPK3PXP")
+	if err != nil || sealed != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Seal without a key = %q, %v", sealed, err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("")
+	if err != nil || key != nil {
+		t.Errorf("ParseKey(\"\") = %v, %v", key, err)
+	}
+
+	_, err = ParseKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	if err == nil {
+		t.Error("accepted a short key")
+	}
+
+	_, err = ParseKey(base64.StdEncoding.EncodeToString(make([]byte, KeySize)))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -2,6 +2,8 @@ package uploader
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 
@@ -22,6 +24,18 @@ func New(cloudName, apiKey, apiSecret string) *ImageUploaderService {
 	return &ImageUploaderService{cloud: cld}
 }
 
+// Ping checks that Cloudinary can be reached with the configured credentials
+func (i *ImageUploaderService) Ping(ctx context.Context) error {
+	res, err := i.cloud.Admin.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Error.Message != "" {
+		return errors.New(res.Error.Message)
+	}
+	return nil
+}
+
 // UploadImage uploads an image to Cloudinary and returns the secure URL and public ID
 func (i *ImageUploaderService) UploadImage(ctx context.Context, file io.Reader) (string, string, error) {
 	uploadParams := uploader.UploadParams{}
@@ -34,9 +48,16 @@ func (i *ImageUploaderService) UploadImage(ctx context.Context, file io.Reader)
 	return uploadResult.SecureURL, uploadResult.PublicID, nil
 }
 
-// DeleteImage deletes an image from Cloudinary using the public ID
+// DeleteImage deletes an image from Cloudinary using the public ID. An image that is
+// already gone counts as deleted, so a deletion can safely be retried.
 func (i *ImageUploaderService) DeleteImage(ctx context.Context, publicID string) error {
-	_, err := i.cloud.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
+	res, err := i.cloud.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
+	if err == nil && res.Error.Message != "" {
+		err = errors.New(res.Error.Message)
+	}
+	if err == nil && res.Result != "ok" && res.Result != "not found" {
+		err = fmt.Errorf("unexpected result %q", res.Result)
+	}
 	if err != nil {
 		log.Printf("Failed to delete image from Cloudinary: %v", err)
 		return err
@@ -0,0 +1,71 @@
+package validator
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	// embed the IANA time zone database, so timezones are checked against the same list
+	// whether or not the host has tzdata installed
+	_ "time/tzdata"
+)
+
+// E164RX matches phone numbers in E.164 format, such as +2348012345678
+var E164RX = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// Timezone returns true if value is an IANA time zone name, such as Africa/Lagos
+func Timezone(value string) bool {
+	// LoadLocation treats "" as UTC and "Local" as the server's zone, neither of which
+	// means anything to a client
+	if value == "" || value == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(value)
+	return err == nil
+}
+
+// PhoneE164 returns true if value is a phone number in E.164 format
+func PhoneE164(value string) bool {
+	return E164RX.MatchString(value)
+}
+
+// CountryCode returns true if value is an ISO 3166-1 alpha-2 country code, such as NG
+func CountryCode(value string) bool {
+	return countryCodes[value]
+}
+
+// CurrencyCode returns true if value is an ISO 4217 currency code, such as NGN
+func CurrencyCode(value string) bool {
+	return currencyCodes[value]
+}
+
+// URL returns true if value is an absolute http or https URL
+func URL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// MinRunes returns true if value has at least n characters. Lengths are counted in
+// runes rather than bytes, so names with accents aren't held to a shorter limit.
+func MinRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// MaxRunes returns true if value has no more than n characters
+func MaxRunes(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+// CheckDateRange checks that both ends of a range are set and that the end is after
+// the start. Errors are reported against the end that is at fault.
+func (v *Validator) CheckDateRange(start, end time.Time, startKey, endKey string) {
+	v.Check(!start.IsZero(), startKey, "validation.required")
+	v.Check(!end.IsZero(), endKey, "validation.required")
+	if !start.IsZero() && !end.IsZero() {
+		v.Check(start.Before(end), endKey, "validation.after", "field", startKey)
+	}
+}
@@ -2,6 +2,9 @@ package validator
 
 import (
 	"regexp"
+	"strconv"
+
+	"github.com/araromirichard/internal/i18n"
 )
 
 // Declare a regular expression for sanity checking the format of email addresses
@@ -12,14 +15,25 @@ var (
 )
 
 
-// Define a new Validator type which contains a map of validation errors.
+// Message is a validation error as a code in the i18n catalogues, along with the values
+// of its placeholders given as name, value pairs
+type Message struct {
+	Code string
+	Args []string
+}
+
+// Define a new Validator type which contains a map of validation errors in English, and
+// the messages they were made from so they can be translated. Validators returned by
+// Field and Index share the maps with their parent and prefix their keys.
 type Validator struct {
-	Errors map[string]string
+	Errors   map[string]string
+	Messages map[string]Message
+	prefix   string
 }
 
-// New is a helper which creates a new Validator instance with an empty errors map.
+// New is a helper which creates a new Validator instance with empty errors maps.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Messages: make(map[string]Message)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -27,18 +41,55 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
-func (v *Validator) AddError(key, message string) {
+// AddError adds the message with the given code to the map (so long as no entry already
+// exists for the given key). args fill in the message's placeholders, as name, value pairs.
+func (v *Validator) AddError(key, code string, args ...string) {
+	key = v.key(key)
 	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+		v.Errors[key] = i18n.T(i18n.Default, code, args...)
+		v.Messages[key] = Message{Code: code, Args: args}
+	}
+}
+
+// Translate returns the errors in the given locale
+func (v *Validator) Translate(locale string) map[string]string {
+	errors := make(map[string]string, len(v.Messages))
+	for key, message := range v.Messages {
+		errors[key] = i18n.T(locale, message.Code, message.Args...)
+	}
+	return errors
+}
+
+// Field returns a validator for a nested object. Its errors are added to v under keys
+// prefixed with the field name, so a check on "phone" is reported as "guardian.phone".
+func (v *Validator) Field(name string) *Validator {
+	return &Validator{Errors: v.Errors, Messages: v.Messages, prefix: v.key(name)}
+}
+
+// Index returns a validator for the element at index i of a list, so a check on
+// "end_year" is reported as "education[2].end_year". An empty key refers to the
+// element itself, which suits lists of plain values.
+func (v *Validator) Index(name string, i int) *Validator {
+	return &Validator{Errors: v.Errors, Messages: v.Messages, prefix: v.key(name + "[" + strconv.Itoa(i) + "]")}
+}
+
+func (v *Validator) key(key string) string {
+	switch {
+	case v.prefix == "":
+		return key
+	case key == "":
+		return v.prefix
+	case key[0] == '[':
+		return v.prefix + key
+	default:
+		return v.prefix + "." + key
 	}
 }
 
 // Check adds an error message to the map only if a validation check is not 'ok'.
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key, code string, args ...string) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, code, args...)
 	}
 }
 
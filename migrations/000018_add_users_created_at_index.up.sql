@@ -0,0 +1,3 @@
+-- Support the admin statistics aggregates over signup dates
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
+CREATE INDEX IF NOT EXISTS idx_tutors_verification ON tutors(verification);
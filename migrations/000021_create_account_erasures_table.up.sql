@@ -0,0 +1,19 @@
+-- Erased accounts keep their users row as an anonymised tombstone so that records we are
+-- required to retain can still reference it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at timestamp(0) with time zone;
+
+-- Create the account_erasures table that tracks erasure requests through their grace period
+CREATE TABLE IF NOT EXISTS account_erasures (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending',
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    scheduled_for timestamp(0) with time zone NOT NULL,
+    cancelled_at timestamp(0) with time zone,
+    completed_at timestamp(0) with time zone,
+    CONSTRAINT check_account_erasure_status CHECK (status IN ('pending', 'cancelled', 'completed'))
+);
+
+-- A user can only have one erasure waiting at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_erasures_pending_user ON account_erasures(user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_account_erasures_scheduled_for ON account_erasures(scheduled_for) WHERE status = 'pending';
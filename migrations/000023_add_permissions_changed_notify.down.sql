@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS roles_permissions_changed ON roles_permissions;
+DROP TRIGGER IF EXISTS users_roles_changed ON users_roles;
+DROP TRIGGER IF EXISTS users_permissions_changed ON users_permissions;
+DROP FUNCTION IF EXISTS notify_permissions_changed();
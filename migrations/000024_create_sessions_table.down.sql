@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tokens_session_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS created_at;
+ALTER TABLE tokens DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,3 @@
+-- Refresh tokens are kept after they are rotated, so that presenting one a second
+-- time can be detected and the whole session revoked
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
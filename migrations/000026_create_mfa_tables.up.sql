@@ -0,0 +1,28 @@
+-- TOTP secrets. A row with a NULL enabled_at is an enrolment that hasn't been confirmed yet.
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret text NOT NULL,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    enabled_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Single use recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+-- Account roles (admin, tutor, student) whose users must use two-factor authentication
+CREATE TABLE IF NOT EXISTS mfa_role_policies (
+    role text PRIMARY KEY,
+    required boolean NOT NULL DEFAULT false,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Sessions started with a second factor
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa_verified boolean NOT NULL DEFAULT false;
@@ -0,0 +1,19 @@
+-- Every password or two-factor check made at login, used to slow down and lock out
+-- repeated guessing
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id bigserial PRIMARY KEY,
+    email citext NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    succeeded boolean NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email_created_at ON login_attempts(email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_created_at ON login_attempts(ip, created_at);
+
+-- Accounts temporarily locked after too many failed logins
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    locked_until timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
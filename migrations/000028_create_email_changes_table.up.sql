@@ -0,0 +1,17 @@
+-- Requests to change a user's email address. The change only happens once the new
+-- address is confirmed, and the old address can revert it.
+CREATE TABLE IF NOT EXISTS email_changes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    old_email citext NOT NULL,
+    new_email citext NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'reverted', 'cancelled')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    confirmed_at timestamp(0) with time zone,
+    reverted_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_changes_user_id ON email_changes(user_id);
+
+-- Two accounts can't both be waiting to move to the same address
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_changes_pending_new_email ON email_changes(new_email) WHERE status = 'pending';
@@ -0,0 +1,31 @@
+-- Service accounts are users with the 'service' role, so that API key requests have an
+-- acting user like any other request
+ALTER TABLE users DROP CONSTRAINT IF EXISTS check_role;
+ALTER TABLE users ADD CONSTRAINT check_role CHECK (role IN ('admin', 'tutor', 'student', 'service'));
+
+CREATE TABLE IF NOT EXISTS service_accounts (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    name citext UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    created_by bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Long-lived keys for service accounts. Only a SHA-256 hash of each key is stored, along
+-- with a short prefix that identifies it in listings and logs.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    service_account_id bigint NOT NULL REFERENCES service_accounts(user_id) ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text UNIQUE NOT NULL,
+    hash bytea UNIQUE NOT NULL,
+    permissions text[] NOT NULL,
+    allowed_ips text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone,
+    revoked_at timestamp(0) with time zone,
+    replaced_by bigint REFERENCES api_keys(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_service_account_id ON api_keys(service_account_id);
@@ -0,0 +1,2 @@
+-- The language emails and API messages are sent to the user in, empty to follow Accept-Language
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+-- Overrides of the email templates embedded in the binary. Every save is a new version,
+-- and at most one version of each template and locale is in use at a time.
+CREATE TABLE IF NOT EXISTS email_templates (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    locale text NOT NULL,
+    version integer NOT NULL,
+    source text NOT NULL,
+    active boolean NOT NULL DEFAULT false,
+    created_by bigint REFERENCES users(id) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (name, locale, version)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_templates_active ON email_templates(name, locale) WHERE active;
@@ -0,0 +1 @@
+ALTER TABLE account_erasures DROP COLUMN IF EXISTS claimed_until;
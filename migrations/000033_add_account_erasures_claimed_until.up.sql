@@ -0,0 +1,3 @@
+-- Every instance runs the erasure job, so an instance claims the erasures it is about to
+-- process until claimed_until. A claim that outlives a crashed instance simply expires.
+ALTER TABLE account_erasures ADD COLUMN IF NOT EXISTS claimed_until timestamp(0) with time zone;
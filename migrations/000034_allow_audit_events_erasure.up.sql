@@ -0,0 +1,23 @@
+-- audit_events stays append-only, with one exception: when an account is erased, the
+-- erasure transaction sets audit.erasure and may then blank the snapshots and IP address
+-- of events about that user. Every other column must stay as it was, and nothing can be
+-- deleted.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('audit.erasure', true) = 'on' THEN
+        IF NEW.id = OLD.id
+            AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+            AND NEW.action = OLD.action
+            AND NEW.entity_type = OLD.entity_type
+            AND NEW.entity_id = OLD.entity_id
+            AND NEW.request_id = OLD.request_id
+            AND NEW.created_at = OLD.created_at
+            AND (NEW.snapshot_before IS NULL OR NEW.snapshot_before = OLD.snapshot_before)
+            AND (NEW.snapshot_after IS NULL OR NEW.snapshot_after = OLD.snapshot_after)
+            AND (NEW.ip = '' OR NEW.ip = OLD.ip) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
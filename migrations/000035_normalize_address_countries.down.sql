@@ -0,0 +1,2 @@
+-- The original free text isn't kept, so there is nothing to restore.
+SELECT 1;
@@ -0,0 +1,32 @@
+-- Addresses are now validated as ISO 3166-1 alpha-2 codes on both registration and
+-- update. Rows saved before that may hold free text, so convert the codes and country
+-- names we can recognise. Anything left over is kept as is; the user is asked for a
+-- valid code the next time they change their address.
+UPDATE addresses SET country = UPPER(TRIM(country))
+WHERE LENGTH(TRIM(country)) = 2;
+
+UPDATE addresses a SET country = m.code
+FROM (VALUES
+    ('nigeria', 'NG'),
+    ('ghana', 'GH'),
+    ('kenya', 'KE'),
+    ('south africa', 'ZA'),
+    ('egypt', 'EG'),
+    ('cameroon', 'CM'),
+    ('benin', 'BJ'),
+    ('togo', 'TG'),
+    ('united kingdom', 'GB'),
+    ('uk', 'GB'),
+    ('great britain', 'GB'),
+    ('england', 'GB'),
+    ('united states', 'US'),
+    ('united states of america', 'US'),
+    ('usa', 'US'),
+    ('canada', 'CA'),
+    ('france', 'FR'),
+    ('germany', 'DE'),
+    ('ireland', 'IE'),
+    ('united arab emirates', 'AE'),
+    ('uae', 'AE')
+) AS m(name, code)
+WHERE LOWER(TRIM(a.country)) = m.name;
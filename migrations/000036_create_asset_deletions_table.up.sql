@@ -0,0 +1,8 @@
+-- Images waiting to be destroyed on Cloudinary. Erasing an account queues its photos here
+-- in the same transaction that deletes them, and they are destroyed once it commits, so
+-- a rolled back or cancelled erasure never loses a photo and a failed call is retried.
+CREATE TABLE IF NOT EXISTS asset_deletions (
+    id bigserial PRIMARY KEY,
+    public_id text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS mfa_role_policies_changed ON mfa_role_policies;
+DROP FUNCTION IF EXISTS notify_mfa_policies_changed();
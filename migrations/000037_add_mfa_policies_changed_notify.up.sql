@@ -0,0 +1,12 @@
+-- Notify listeners whenever an MFA role policy changes, so that the policies cached on
+-- every instance are dropped
+CREATE OR REPLACE FUNCTION notify_mfa_policies_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('mfa_policies_changed', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER mfa_role_policies_changed
+    AFTER INSERT OR UPDATE OR DELETE ON mfa_role_policies
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_mfa_policies_changed();
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_data_exports_pending_user;
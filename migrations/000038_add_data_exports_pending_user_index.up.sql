@@ -0,0 +1,9 @@
+-- Fail all but the newest pending export for each user, so the index below can be built
+UPDATE data_exports
+SET status = 'failed', error = 'superseded by a newer export', completed_at = NOW()
+WHERE status = 'pending' AND id NOT IN (
+    SELECT MAX(id) FROM data_exports WHERE status = 'pending' GROUP BY user_id
+);
+
+-- Only one export per user can be generated at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_pending_user ON data_exports(user_id) WHERE status = 'pending';